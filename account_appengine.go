@@ -6,6 +6,7 @@ import (
 	"context"
 	"net/http"
 
+	"github.com/pkg/errors"
 	"google.golang.org/appengine"
 	"google.golang.org/appengine/urlfetch"
 )
@@ -14,6 +15,10 @@ func getDefaultServiceAccountEmail(ctx context.Context, cfg Config) (string, err
 	return appengine.ServiceAccount(ctx)
 }
 
+func getIdentityToken(ctx context.Context, cfg Config, audience string) (string, error) {
+	return "", errors.New("the gce role type is not supported on App Engine")
+}
+
 func getHTTPClient(ctx context.Context, _ Config) *http.Client {
 	return urlfetch.Client(ctx)
 }
@@ -6,28 +6,42 @@ import (
 	"context"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
+	"github.com/cenkalti/backoff/v4"
 	"github.com/pkg/errors"
 )
 
 func getDefaultServiceAccountEmail(ctx context.Context, cfg Config) (string, error) {
-	result, err := callMetadataService(ctx, cfg)
+	result, err := callMetadataService(ctx, cfg,
+		"/computeMetadata/v1/instance/service-accounts/default/email")
 	if err != nil {
 		return "", errors.Wrap(err, "unable to retrieve default service account email")
 	}
 	return result, nil
 }
 
-func callMetadataService(ctx context.Context, cfg Config) (string, error) {
+// getIdentityToken fetches a signed instance identity token for the default service
+// account, as expected by Vault's 'gce' role type.
+// https://www.vaultproject.io/docs/auth/gcp.html#gce-login
+func getIdentityToken(ctx context.Context, cfg Config, audience string) (string, error) {
+	result, err := callMetadataService(ctx, cfg,
+		"/computeMetadata/v1/instance/service-accounts/default/identity?audience="+
+			url.QueryEscape(audience)+"&format=full")
+	if err != nil {
+		return "", errors.Wrap(err, "unable to retrieve instance identity token")
+	}
+	return result, nil
+}
+
+func callMetadataService(ctx context.Context, cfg Config, path string) (string, error) {
 	c := getHTTPClient(ctx, cfg)
 	if cfg.MetadataAddress == "" {
 		cfg.MetadataAddress = "http://metadata"
 	}
-	r, err := http.NewRequest(http.MethodGet,
-		cfg.MetadataAddress+
-			"/computeMetadata/v1/instance/service-accounts/default/email", nil)
+	r, err := http.NewRequest(http.MethodGet, cfg.MetadataAddress+path, nil)
 	if err != nil {
 		return "", errors.Wrap(err, "unable create metadata request")
 	}
@@ -40,8 +54,13 @@ func callMetadataService(ctx context.Context, cfg Config) (string, error) {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", errors.Errorf("unexpected status response from metadata service: %d",
+		err := errors.Errorf("unexpected status response from metadata service: %d",
 			resp.StatusCode)
+		// only rate limiting and server errors are worth retrying
+		if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < http.StatusInternalServerError {
+			return "", backoff.Permanent(err)
+		}
+		return "", err
 	}
 
 	bod, err := ioutil.ReadAll(resp.Body)
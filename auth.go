@@ -0,0 +1,269 @@
+package gcpvault
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// AuthMethod logs in to Vault. The returned secret's Auth must hold the client
+// token, and its lease duration is used as the token's TTL for caching and renewal.
+type AuthMethod interface {
+	Login(ctx context.Context, vClient *api.Client) (*api.Secret, error)
+}
+
+// AuthType values select one of the built in AuthMethods from the environment.
+const (
+	AuthTypeGCP        = "gcp"
+	AuthTypeKubernetes = "kubernetes"
+	AuthTypeAppRole    = "approle"
+	AuthTypeToken      = "token"
+)
+
+// KubernetesTokenPathDefault is where Kubernetes mounts a pod's service account JWT.
+const KubernetesTokenPathDefault = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// newAuthMethod returns the built in AuthMethod selected by cfg.AuthType.
+func newAuthMethod(cfg Config) (AuthMethod, error) {
+	switch cfg.AuthType {
+	case AuthTypeGCP:
+		a := newGCPAuth(cfg)
+		return a, a.err
+	case AuthTypeKubernetes:
+		return KubernetesAuth{
+			MountPath: cfg.AuthPath,
+			Role:      cfg.Role,
+			TokenPath: cfg.KubernetesTokenPath,
+		}, nil
+	case AuthTypeAppRole:
+		return AppRoleAuth{
+			MountPath: cfg.AuthPath,
+			RoleID:    cfg.AppRoleID,
+			SecretID:  cfg.AppRoleSecretID,
+		}, nil
+	case AuthTypeToken:
+		return TokenAuth{}, nil
+	default:
+		return nil, errors.Errorf("unsupported auth type %q", cfg.AuthType)
+	}
+}
+
+// NewGCPIAMAuth returns an AuthMethod that logs in to a GCP auth 'iam' role with a
+// JWT signed for the default credentials, as configured by cfg. This is the
+// AuthMethod used when none is configured.
+func NewGCPIAMAuth(cfg Config) AuthMethod {
+	cfg.RoleType = RoleTypeIAM
+	return newGCPAuth(cfg)
+}
+
+// NewGCPGCEAuth returns an AuthMethod that logs in to a GCP auth 'gce' role with an
+// instance identity token from the metadata server, as configured by cfg.
+func NewGCPGCEAuth(cfg Config) AuthMethod {
+	cfg.RoleType = RoleTypeGCE
+	return newGCPAuth(cfg)
+}
+
+type gcpAuth struct {
+	cfg Config
+	// err is an invalid setting in cfg, reported by Login.
+	err error
+}
+
+// newGCPAuth applies the GCP login defaults to cfg once, up front.
+func newGCPAuth(cfg Config) gcpAuth {
+	if cfg.AuthPath == "" {
+		cfg.AuthPath = "auth/gcp"
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = TokenCacheMaxRetriesDefault
+	}
+	err := checkGCPDefaults(&cfg)
+	return gcpAuth{cfg: cfg, err: err}
+}
+
+func (a gcpAuth) Login(ctx context.Context, vClient *api.Client) (*api.Secret, error) {
+	if a.err != nil {
+		return nil, a.err
+	}
+	cfg := a.cfg
+
+	// create signed JWT with our service account
+	jwt, err := newJWT(ctx, cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create JWT")
+	}
+
+	// 'login' to vault using GCP auth
+	resp, err := vClient.Logical().WriteWithContext(ctx, cfg.AuthPath+"/login", map[string]interface{}{
+		"role": cfg.Role, "jwt": jwt,
+	})
+	if err != nil {
+		if claim := rejectedJWTClaim(err); claim != "" {
+			return nil, &Error{Kind: ErrPermissionDenied, Err: &JWTRejectedError{Claim: claim, Err: err}}
+		}
+		return nil, wrapVaultError(err, "unable to make login request")
+	}
+
+	return resp, nil
+}
+
+// JWTRejectedError is returned when Vault refuses a GCP login because of the JWT's
+// 'exp' or 'aud' claim, which usually means JWTExpiration exceeds the role's
+// max_jwt_exp or JWTAudience does not match what Vault expects.
+type JWTRejectedError struct {
+	// Claim is either 'exp' or 'aud'.
+	Claim string
+	Err   error
+}
+
+func (e *JWTRejectedError) Error() string {
+	hint := "check that JWTExpiration is within the role's max_jwt_exp"
+	if e.Claim == "aud" {
+		hint = "check that JWTAudience matches the audience Vault expects"
+	}
+	return fmt.Sprintf("vault rejected the JWT's %s claim, %s: %s", e.Claim, hint, e.Err)
+}
+
+func (e *JWTRejectedError) Unwrap() error {
+	return e.Err
+}
+
+// rejectedJWTClaim reports which claim, if any, a failed GCP login complained about.
+func rejectedJWTClaim(err error) string {
+	var respErr *api.ResponseError
+	if !errors.As(err, &respErr) || respErr.StatusCode >= http.StatusInternalServerError {
+		return ""
+	}
+	for _, msg := range respErr.Errors {
+		msg = strings.ToLower(msg)
+		switch {
+		case strings.Contains(msg, "audience") || strings.Contains(msg, "(aud)"):
+			return "aud"
+		case strings.Contains(msg, "(exp)") || strings.Contains(msg, "exp claim") ||
+			strings.Contains(msg, "max_jwt_exp") || strings.Contains(msg, "expir"):
+			return "exp"
+		}
+	}
+	return ""
+}
+
+// KubernetesAuth logs in with Vault's Kubernetes auth method using the pod's service
+// account JWT.
+// https://www.vaultproject.io/docs/auth/kubernetes
+type KubernetesAuth struct {
+	// MountPath is where the auth method is mounted. Defaults to 'auth/kubernetes'.
+	MountPath string
+	// Role is the Vault role bound to the service account.
+	Role string
+	// TokenPath is the file holding the service account JWT. Defaults to
+	// KubernetesTokenPathDefault.
+	TokenPath string
+}
+
+func (a KubernetesAuth) Login(ctx context.Context, vClient *api.Client) (*api.Secret, error) {
+	if a.MountPath == "" {
+		a.MountPath = "auth/kubernetes"
+	}
+	if a.TokenPath == "" {
+		a.TokenPath = KubernetesTokenPathDefault
+	}
+
+	jwt, err := ioutil.ReadFile(a.TokenPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read service account token")
+	}
+
+	resp, err := vClient.Logical().WriteWithContext(ctx, a.MountPath+"/login", map[string]interface{}{
+		"role": a.Role, "jwt": strings.TrimSpace(string(jwt)),
+	})
+	if err != nil {
+		return nil, wrapVaultError(err, "unable to make login request")
+	}
+
+	return resp, nil
+}
+
+// AppRoleAuth logs in with Vault's AppRole auth method.
+// https://www.vaultproject.io/docs/auth/approle
+type AppRoleAuth struct {
+	// MountPath is where the auth method is mounted. Defaults to 'auth/approle'.
+	MountPath string
+	RoleID    string
+	// SecretID may be left empty for roles that do not require one.
+	SecretID string
+}
+
+func (a AppRoleAuth) Login(ctx context.Context, vClient *api.Client) (*api.Secret, error) {
+	if a.MountPath == "" {
+		a.MountPath = "auth/approle"
+	}
+	if a.RoleID == "" {
+		return nil, errors.New("approle login requires a role ID")
+	}
+
+	data := map[string]interface{}{"role_id": a.RoleID}
+	if a.SecretID != "" {
+		data["secret_id"] = a.SecretID
+	}
+	resp, err := vClient.Logical().WriteWithContext(ctx, a.MountPath+"/login", data)
+	if err != nil {
+		return nil, wrapVaultError(err, "unable to make login request")
+	}
+
+	return resp, nil
+}
+
+// TokenAuth uses an existing Vault token, such as one obtained with `vault login`
+// using OIDC or userpass. If Token is empty, the VAULT_TOKEN environment variable is
+// used, followed by the token saved by the Vault CLI's default token helper in
+// ~/.vault-token. The token is looked up to find its TTL.
+type TokenAuth struct {
+	Token string
+}
+
+func (a TokenAuth) Login(ctx context.Context, vClient *api.Client) (*api.Secret, error) {
+	token := a.Token
+	if token == "" {
+		token = vClient.Token()
+	}
+	if token == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to find home directory")
+		}
+		b, err := ioutil.ReadFile(filepath.Join(home, ".vault-token"))
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read token helper file")
+		}
+		token = strings.TrimSpace(string(b))
+	}
+	if token == "" {
+		return nil, errors.New("no vault token found")
+	}
+
+	vClient.SetToken(token)
+	self, err := vClient.Auth().Token().LookupSelfWithContext(ctx)
+	if err != nil {
+		return nil, wrapVaultError(err, "unable to look up token")
+	}
+	ttl, err := self.TokenTTL()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to retrieve token ttl")
+	}
+	renewable, _ := self.TokenIsRenewable()
+
+	return &api.Secret{
+		Auth: &api.SecretAuth{
+			ClientToken:   token,
+			LeaseDuration: int(ttl.Seconds()),
+			Renewable:     renewable,
+		},
+	}, nil
+}
@@ -0,0 +1,286 @@
+package gcpvault
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/hashicorp/vault/api"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/iam/v1"
+)
+
+func TestAuthMethods(t *testing.T) {
+	jwtFile := filepath.Join(t.TempDir(), "token")
+	if err := ioutil.WriteFile(jwtFile, []byte("k8s-jwt\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		givenCfg Config
+
+		wantPath  string
+		wantBody  map[string]interface{}
+		wantToken string
+		wantErr   bool
+	}{
+		{
+			name: "kubernetes from config",
+			givenCfg: Config{
+				AuthType:            AuthTypeKubernetes,
+				Role:                "my-k8s-role",
+				KubernetesTokenPath: jwtFile,
+			},
+
+			wantPath:  "/v1/auth/kubernetes/login",
+			wantBody:  map[string]interface{}{"role": "my-k8s-role", "jwt": "k8s-jwt"},
+			wantToken: "vault-login-token",
+		},
+		{
+			name: "approle from config",
+			givenCfg: Config{
+				AuthType:        AuthTypeAppRole,
+				AppRoleID:       "my-role-id",
+				AppRoleSecretID: "my-secret-id",
+			},
+
+			wantPath:  "/v1/auth/approle/login",
+			wantBody:  map[string]interface{}{"role_id": "my-role-id", "secret_id": "my-secret-id"},
+			wantToken: "vault-login-token",
+		},
+		{
+			name: "approle with custom mount",
+			givenCfg: Config{
+				AuthMethod: AppRoleAuth{MountPath: "auth/onprem", RoleID: "my-role-id"},
+			},
+
+			wantPath:  "/v1/auth/onprem/login",
+			wantBody:  map[string]interface{}{"role_id": "my-role-id"},
+			wantToken: "vault-login-token",
+		},
+		{
+			name: "static token",
+			givenCfg: Config{
+				AuthMethod: TokenAuth{Token: "my-static-token"},
+			},
+
+			wantPath:  "/v1/auth/token/lookup-self",
+			wantToken: "my-static-token",
+		},
+		{
+			name: "unknown auth type",
+			givenCfg: Config{
+				AuthType: "ldap",
+			},
+
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				gotPath string
+				gotBody map[string]interface{}
+			)
+			vaultSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotPath = r.URL.Path
+				json.NewDecoder(r.Body).Decode(&gotBody)
+				if r.Method == http.MethodGet {
+					json.NewEncoder(w).Encode(api.Secret{
+						Data: map[string]interface{}{"ttl": 3600, "renewable": true},
+					})
+					return
+				}
+				json.NewEncoder(w).Encode(api.Secret{
+					Auth: &api.SecretAuth{ClientToken: "vault-login-token", LeaseDuration: 3600},
+				})
+			}))
+			defer vaultSvr.Close()
+
+			cfg := test.givenCfg
+			cfg.VaultAddress = vaultSvr.URL
+
+			c, err := NewClient(context.Background(), cfg)
+			if test.wantErr {
+				if err == nil {
+					t.Error("expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			defer c.Close()
+
+			if gotPath != test.wantPath {
+				t.Errorf("expected login at %q, got %q", test.wantPath, gotPath)
+			}
+			if !cmp.Equal(test.wantBody, gotBody) {
+				t.Errorf("login body differs: (-want +got)\n%s", cmp.Diff(test.wantBody, gotBody))
+			}
+			if c.token.Token != test.wantToken {
+				t.Errorf("expected token %q, got %q", test.wantToken, c.token.Token)
+			}
+			if c.token.Expires.IsZero() {
+				t.Error("expected token to have an expiry")
+			}
+		})
+	}
+}
+
+func TestGCPAuthJWTClaims(t *testing.T) {
+	tests := []struct {
+		name          string
+		givenCfg      Config
+		givenVaultErr string
+
+		wantClaims   map[string]interface{}
+		wantExp      time.Duration
+		wantRejected string
+		wantErr      bool
+	}{
+		{
+			name: "defaults",
+			givenCfg: Config{
+				Role: "my-gcp-role",
+			},
+
+			wantClaims: map[string]interface{}{"aud": "vault/my-gcp-role", "sub": "jp@example.com"},
+			wantExp:    5 * time.Minute,
+		},
+		{
+			name: "custom audience, exp and claims",
+			givenCfg: Config{
+				Role:          "my-gcp-role",
+				JWTAudience:   "https://vault.example.com/my-gcp-role",
+				JWTExpiration: 60,
+				JWTClaims:     map[string]interface{}{"env": "prd"},
+			},
+
+			wantClaims: map[string]interface{}{
+				"aud": "https://vault.example.com/my-gcp-role",
+				"sub": "jp@example.com",
+				"env": "prd",
+			},
+			wantExp: time.Minute,
+		},
+		{
+			name: "exp above max",
+			givenCfg: Config{
+				Role:          "my-gcp-role",
+				JWTExpiration: 3600,
+			},
+
+			wantErr: true,
+		},
+		{
+			name: "reserved claim",
+			givenCfg: Config{
+				Role:      "my-gcp-role",
+				JWTClaims: map[string]interface{}{"sub": "someone-else"},
+			},
+
+			wantErr: true,
+		},
+		{
+			name: "vault rejects exp",
+			givenCfg: Config{
+				Role: "my-gcp-role",
+			},
+			givenVaultErr: "JWT expires in 300 seconds but must expire within 60 seconds for this role",
+
+			wantRejected: "exp",
+			wantErr:      true,
+		},
+		{
+			name: "vault rejects aud",
+			givenCfg: Config{
+				Role: "my-gcp-role",
+			},
+			givenVaultErr: "square/go-jose/jwt: validation failed, invalid audience claim (aud)",
+
+			wantRejected: "aud",
+			wantErr:      true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var gotClaims map[string]interface{}
+			iamSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var body struct {
+					Payload string `json:"payload"`
+				}
+				json.NewDecoder(r.Body).Decode(&body)
+				json.Unmarshal([]byte(body.Payload), &gotClaims)
+				json.NewEncoder(w).Encode(iam.SignJwtResponse{SignedJwt: "gcp-signed-jwt-for-vault"})
+			}))
+			defer iamSvr.Close()
+
+			vaultSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if test.givenVaultErr != "" {
+					w.WriteHeader(http.StatusBadRequest)
+					json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{test.givenVaultErr}})
+					return
+				}
+				json.NewEncoder(w).Encode(api.Secret{
+					Auth: &api.SecretAuth{ClientToken: "vault-test-token"},
+				})
+			}))
+			defer vaultSvr.Close()
+
+			findDefaultCredentials = func(ctx context.Context, scopes ...string) (*google.Credentials, error) {
+				return &google.Credentials{
+					TokenSource: testTokenSource{},
+					JSON:        []byte(`{"client_email": "jp@example.com"}`),
+				}, nil
+			}
+			defer func() {
+				findDefaultCredentials = google.FindDefaultCredentials
+			}()
+
+			cfg := test.givenCfg
+			cfg.VaultAddress = vaultSvr.URL
+			cfg.IAMAddress = iamSvr.URL
+
+			start := time.Now()
+			c, err := NewClient(context.Background(), cfg)
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("expected error, got none")
+				}
+				var rejected *JWTRejectedError
+				if errors.As(err, &rejected) != (test.wantRejected != "") {
+					t.Fatalf("unexpected rejection error: %s", err)
+				}
+				if rejected != nil && rejected.Claim != test.wantRejected {
+					t.Errorf("expected %q claim to be rejected, got %q", test.wantRejected, rejected.Claim)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			defer c.Close()
+
+			exp, _ := gotClaims["exp"].(float64)
+			delete(gotClaims, "exp")
+			if !cmp.Equal(test.wantClaims, gotClaims) {
+				t.Errorf("claims differ: (-want +got)\n%s", cmp.Diff(test.wantClaims, gotClaims))
+			}
+			gotExp := time.Unix(int64(exp), 0).Sub(start)
+			if gotExp < test.wantExp-time.Second || gotExp > test.wantExp+time.Second {
+				t.Errorf("expected exp %s from now, got %s", test.wantExp, gotExp)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package gcpvault
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// PutOption configures a versioned write.
+type PutOption func(*putOptions)
+
+type putOptions struct {
+	cas *int
+}
+
+// WithCheckAndSet only allows a versioned write to succeed if the secret is currently
+// at the given version. A version of 0 only allows the write if the secret does not
+// exist yet.
+func WithCheckAndSet(version int) PutOption {
+	return func(o *putOptions) {
+		o.cas = &version
+	}
+}
+
+// CheckAndSetError is returned by versioned writes made with WithCheckAndSet when the
+// secret is no longer at the expected version, usually because another writer
+// updated it first.
+type CheckAndSetError struct {
+	Path string
+	// Version is the version the write expected the secret to be at.
+	Version int
+	Err     error
+}
+
+func (e *CheckAndSetError) Error() string {
+	return fmt.Sprintf("check-and-set version %d did not match the current version of %s: %s",
+		e.Version, e.Path, e.Err)
+}
+
+func (e *CheckAndSetError) Unwrap() error {
+	return e.Err
+}
+
+func isCheckAndSetMismatch(err error) bool {
+	var re *api.ResponseError
+	if !errors.As(err, &re) {
+		return false
+	}
+	return strings.Contains(strings.Join(re.Errors, " "), "check-and-set")
+}
+
+// UpdateVersionedSecrets performs a read-modify-write of the versioned secrets at the
+// configured path. See Client.UpdateVersionedSecrets for details.
+func UpdateVersionedSecrets(ctx context.Context, cfg Config, update func(map[string]interface{}) (map[string]interface{}, error)) error {
+	c, err := newOneShotClient(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	return c.UpdateVersionedSecrets(ctx, c.cfg.SecretPath, update)
+}
+
+// UpdateVersionedSecrets reads the versioned secrets at the given path, passes them
+// to update and writes the result back with a check-and-set on the version that was
+// read. If another writer updated the secret in the meantime, the whole cycle is
+// retried up to MaxRetries times, so update may be called more than once and should
+// not have side effects.
+func (c *Client) UpdateVersionedSecrets(ctx context.Context, path string, update func(map[string]interface{}) (map[string]interface{}, error)) error {
+	return c.withVault(ctx, func(vClient *api.Client) error {
+		b := backoff.NewExponentialBackOff()
+		return backoff.Retry(func() error {
+			current, version, err := readVersionedSecretsWithVersion(ctx, vClient, path)
+			if err != nil {
+				return backoff.Permanent(err)
+			}
+
+			updated, err := update(current)
+			if err != nil {
+				return backoff.Permanent(err)
+			}
+
+			err = writeVersionedSecrets(ctx, vClient, path, updated, WithCheckAndSet(version))
+			var casErr *CheckAndSetError
+			if err != nil && !errors.As(err, &casErr) {
+				return backoff.Permanent(err)
+			}
+			return err
+		}, backoff.WithContext(backoff.WithMaxRetries(b, uint64(c.cfg.MaxRetries)), ctx))
+	})
+}
@@ -0,0 +1,231 @@
+package gcpvault
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// Client is a long-lived connection to Vault. It logs in once when created and
+// reuses the resulting token for every request, logging in again only once the token
+// is within the configured TokenCacheRefreshThreshold of expiring.
+//
+// A Client is safe for concurrent use by multiple goroutines.
+type Client struct {
+	cfg Config
+
+	mu      sync.RWMutex
+	vClient *api.Client
+	token   Token
+
+	stopRenewal func()
+	renewalDone chan struct{}
+
+	leaseMu sync.Mutex
+	leases  map[*Lease]struct{}
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewClient creates a Client from the given Config and logs in to Vault. If
+// Config.RenewToken is set, the token will be kept alive in the background until
+// Close is called.
+func NewClient(ctx context.Context, cfg Config) (*Client, error) {
+	c, err := newOneShotClient(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.cfg.RenewToken && c.cfg.LocalToken == "" {
+		rctx, cancel := context.WithCancel(context.Background())
+		c.stopRenewal = cancel
+		c.renewalDone = make(chan struct{})
+		go c.renewToken(rctx)
+	}
+	return c, nil
+}
+
+// newOneShotClient creates a logged in Client for the package level functions,
+// which use it for a single call and never Close it, so its token is not renewed.
+func newOneShotClient(ctx context.Context, cfg Config) (*Client, error) {
+	err := checkDefaults(&cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{cfg: cfg}
+	err = c.login(ctx, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to login to vault")
+	}
+	return c, nil
+}
+
+// Close revokes any leases obtained through the Client and stops any background
+// work it started.
+func (c *Client) Close() error {
+	c.closeOnce.Do(func() {
+		c.leaseMu.Lock()
+		leases := make([]*Lease, 0, len(c.leases))
+		for l := range c.leases {
+			leases = append(leases, l)
+		}
+		c.leaseMu.Unlock()
+
+		for _, l := range leases {
+			if err := l.revoke(); err != nil && c.closeErr == nil {
+				c.closeErr = err
+			}
+		}
+
+		if c.stopRenewal != nil {
+			c.stopRenewal()
+			<-c.renewalDone
+		}
+	})
+	return c.closeErr
+}
+
+// GetSecrets reads the secrets stored at the given path.
+// This is comparable to the `vault read` command.
+func (c *Client) GetSecrets(ctx context.Context, path string) (map[string]interface{}, error) {
+	var secrets map[string]interface{}
+	err := c.withVault(ctx, func(vClient *api.Client) (err error) {
+		secrets, err = readSecrets(ctx, vClient, path)
+		return err
+	})
+	return secrets, err
+}
+
+// PutSecrets writes secrets to the given path.
+// This is comparable to the `vault write` command.
+func (c *Client) PutSecrets(ctx context.Context, path string, secrets map[string]interface{}) error {
+	return c.withVault(ctx, func(vClient *api.Client) error {
+		return writeSecrets(ctx, vClient, path, secrets)
+	})
+}
+
+// GetVersionedSecrets reads the versioned secrets stored at the given path.
+// This is comparable to the `vault kv get` command.
+func (c *Client) GetVersionedSecrets(ctx context.Context, path string) (map[string]interface{}, error) {
+	var secrets map[string]interface{}
+	err := c.withVault(ctx, func(vClient *api.Client) (err error) {
+		secrets, err = readVersionedSecrets(ctx, vClient, path)
+		return err
+	})
+	return secrets, err
+}
+
+// PutVersionedSecrets writes versioned secrets to the given path.
+// This is comparable to the `vault kv put` command.
+//
+// Passing WithCheckAndSet makes the write conditional on the current version of the
+// secret, returning a *CheckAndSetError if it does not match.
+func (c *Client) PutVersionedSecrets(ctx context.Context, path string, secrets map[string]interface{}, opts ...PutOption) error {
+	return c.withVault(ctx, func(vClient *api.Client) error {
+		return writeVersionedSecrets(ctx, vClient, path, secrets, opts...)
+	})
+}
+
+// vault returns the logged in Vault API client, logging in again first if the
+// current token is about to expire.
+func (c *Client) vault(ctx context.Context) (*api.Client, error) {
+	c.mu.RLock()
+	vClient, token := c.vClient, c.token
+	c.mu.RUnlock()
+	if !c.needsLogin(token) {
+		return vClient, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// another goroutine may have logged in while we waited for the lock
+	if !c.needsLogin(c.token) {
+		return c.vClient, nil
+	}
+	old := c.token.Token
+	err := c.loginLocked(ctx, true)
+	if err == nil && c.token.Token == old {
+		// the cache refreshes tokens up to TokenCacheRefreshRandomOffset later than
+		// we do and handed ours back, so log in rather than read it on every request
+		err = c.loginLocked(ctx, false)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to login to vault")
+	}
+	return c.vClient, nil
+}
+
+// withVault calls fn with the logged in Vault API client. Tokens reused from memory
+// are not checked with Vault and may have been revoked since, so if Vault refuses
+// the request made with one and the token no longer passes a lookup, the Client
+// logs in again and calls fn once more.
+func (c *Client) withVault(ctx context.Context, fn func(*api.Client) error) error {
+	vClient, err := c.vault(ctx)
+	if err != nil {
+		return err
+	}
+	err = fn(vClient)
+	if !errors.Is(err, ErrPermissionDenied) {
+		return err
+	}
+	vClient, ok, lerr := c.reloginRevoked(ctx, vClient)
+	if lerr != nil {
+		return lerr
+	}
+	if !ok {
+		return err
+	}
+	return fn(vClient)
+}
+
+// reloginRevoked logs in again, bypassing the cache, if the token used by vClient
+// was reused from memory without being checked with Vault and has since been
+// revoked. It reports whether the returned client has a different token.
+func (c *Client) reloginRevoked(ctx context.Context, used *api.Client) (*api.Client, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.vClient != used {
+		// another goroutine has logged in again already
+		return c.vClient, true, nil
+	}
+	// a token that still passes a lookup was refused by policy, which a new
+	// login would not change
+	if !c.token.trusted || !isRevoked(ctx, c.cfg, &c.token) {
+		return nil, false, nil
+	}
+	err := c.loginLocked(ctx, false)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "unable to login to vault")
+	}
+	return c.vClient, true, nil
+}
+
+func (c *Client) login(ctx context.Context, readCache bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.loginLocked(ctx, readCache)
+}
+
+func (c *Client) loginLocked(ctx context.Context, readCache bool) error {
+	vClient, token, err := login(ctx, c.cfg, readCache)
+	if err != nil {
+		return err
+	}
+	c.vClient, c.token = vClient, token
+	return nil
+}
+
+// needsLogin reports whether the token is within the refresh threshold of expiring.
+// Tokens without an expiration never need a new login.
+func (c *Client) needsLogin(token Token) bool {
+	if token.Expires.IsZero() {
+		return false
+	}
+	threshold := time.Second * time.Duration(c.cfg.TokenCacheRefreshThreshold)
+	return time.Now().Add(threshold).After(token.Expires)
+}
@@ -0,0 +1,313 @@
+package gcpvault
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/hashicorp/vault/api"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/iam/v1"
+	"google.golang.org/appengine"
+)
+
+func TestClientLogsInOnce(t *testing.T) {
+	if appengine.IsDevAppServer() {
+		t.Skip("in an app engine environment, skipping non GAE test")
+	}
+
+	var gotLogins, gotIAMHits, gotReads int32
+	secrets := map[string]interface{}{"my-sec": "123"}
+
+	vaultSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			atomic.AddInt32(&gotReads, 1)
+			json.NewEncoder(w).Encode(api.Secret{Data: secrets})
+		case http.MethodPut:
+			atomic.AddInt32(&gotLogins, 1)
+			json.NewEncoder(w).Encode(api.Secret{
+				Auth: &api.SecretAuth{ClientToken: "vault-test-token"},
+			})
+		}
+	}))
+	defer vaultSvr.Close()
+
+	iamSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&gotIAMHits, 1)
+		json.NewEncoder(w).Encode(iam.SignJwtResponse{SignedJwt: "gcp-signed-jwt-for-vault"})
+	}))
+	defer iamSvr.Close()
+
+	metaSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "jp@example.com")
+	}))
+	defer metaSvr.Close()
+
+	findDefaultCredentials = func(ctx context.Context, scopes ...string) (*google.Credentials, error) {
+		return &google.Credentials{TokenSource: testTokenSource{}}, nil
+	}
+	defer func() {
+		findDefaultCredentials = google.FindDefaultCredentials
+	}()
+
+	ctx := context.Background()
+	c, err := NewClient(ctx, Config{
+		Role:            "my-gcp-role",
+		VaultAddress:    vaultSvr.URL,
+		IAMAddress:      iamSvr.URL,
+		MetadataAddress: metaSvr.URL,
+	})
+	if err != nil {
+		t.Fatalf("unable to create client: %s", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := c.GetSecrets(ctx, "my-secret-path")
+			if err != nil {
+				t.Errorf("expected no error, got %s", err)
+				return
+			}
+			if !cmp.Equal(secrets, got) {
+				t.Errorf("secrets differ: (-want +got)\n%s", cmp.Diff(secrets, got))
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&gotLogins); got != 1 {
+		t.Errorf("expected 1 Vault login, got %d", got)
+	}
+	if got := atomic.LoadInt32(&gotIAMHits); got != 1 {
+		t.Errorf("expected 1 IAM hit, got %d", got)
+	}
+	if got := atomic.LoadInt32(&gotReads); got != 10 {
+		t.Errorf("expected 10 Vault reads, got %d", got)
+	}
+}
+
+func TestClientRenewToken(t *testing.T) {
+	if appengine.IsDevAppServer() {
+		t.Skip("in an app engine environment, skipping non GAE test")
+	}
+
+	var gotLogins, gotRenewals int32
+	vaultSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/gcp/login":
+			atomic.AddInt32(&gotLogins, 1)
+			json.NewEncoder(w).Encode(api.Secret{
+				Auth: &api.SecretAuth{
+					ClientToken:   "vault-test-token",
+					Renewable:     true,
+					LeaseDuration: 3600,
+				},
+			})
+		case "/v1/auth/token/lookup-self":
+			json.NewEncoder(w).Encode(api.Secret{
+				Data: map[string]interface{}{"ttl": 3600, "renewable": true},
+			})
+		case "/v1/auth/token/renew-self":
+			// the token has hit its max TTL
+			atomic.AddInt32(&gotRenewals, 1)
+			w.WriteHeader(http.StatusForbidden)
+			io.WriteString(w, `{"errors":["permission denied"]}`)
+		}
+	}))
+	defer vaultSvr.Close()
+
+	iamSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(iam.SignJwtResponse{SignedJwt: "gcp-signed-jwt-for-vault"})
+	}))
+	defer iamSvr.Close()
+
+	metaSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "jp@example.com")
+	}))
+	defer metaSvr.Close()
+
+	findDefaultCredentials = func(ctx context.Context, scopes ...string) (*google.Credentials, error) {
+		return &google.Credentials{TokenSource: testTokenSource{}}, nil
+	}
+	defer func() {
+		findDefaultCredentials = google.FindDefaultCredentials
+	}()
+
+	c, err := NewClient(context.Background(), Config{
+		Role:            "my-gcp-role",
+		VaultAddress:    vaultSvr.URL,
+		IAMAddress:      iamSvr.URL,
+		MetadataAddress: metaSvr.URL,
+		RenewToken:      true,
+	})
+	if err != nil {
+		t.Fatalf("unable to create client: %s", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&gotLogins) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Close()
+
+	if got := atomic.LoadInt32(&gotRenewals); got < 1 {
+		t.Errorf("expected a renewal attempt, got %d", got)
+	}
+	if got := atomic.LoadInt32(&gotLogins); got < 2 {
+		t.Errorf("expected a second login after renewal failed, got %d logins", got)
+	}
+}
+
+func TestGetSecretsDoesNotRenew(t *testing.T) {
+	var (
+		done        int32
+		gotRenewals int32
+	)
+	vaultSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/approle/login":
+			if atomic.LoadInt32(&done) == 1 {
+				atomic.AddInt32(&gotRenewals, 1)
+			}
+			json.NewEncoder(w).Encode(api.Secret{
+				Auth: &api.SecretAuth{ClientToken: "vault-test-token", Renewable: true, LeaseDuration: 3600},
+			})
+		case "/v1/auth/token/lookup-self", "/v1/auth/token/renew-self":
+			if atomic.LoadInt32(&done) == 1 {
+				atomic.AddInt32(&gotRenewals, 1)
+			}
+			json.NewEncoder(w).Encode(api.Secret{
+				Data: map[string]interface{}{"ttl": 3600, "renewable": true},
+				Auth: &api.SecretAuth{ClientToken: "vault-test-token", Renewable: true, LeaseDuration: 3600},
+			})
+		default:
+			json.NewEncoder(w).Encode(api.Secret{Data: map[string]interface{}{"my-sec": "123"}})
+		}
+	}))
+	defer vaultSvr.Close()
+
+	_, err := GetSecrets(context.Background(), Config{
+		VaultAddress: vaultSvr.URL,
+		AuthType:     AuthTypeAppRole,
+		AppRoleID:    "my-role-id",
+		SecretPath:   "my-secret-path",
+		RenewToken:   true,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	atomic.StoreInt32(&done, 1)
+
+	time.Sleep(300 * time.Millisecond)
+	if got := atomic.LoadInt32(&gotRenewals); got != 0 {
+		t.Errorf("expected no renewal traffic after GetSecrets returned, got %d requests", got)
+	}
+}
+
+func TestClientRefreshesCachedToken(t *testing.T) {
+	var gotLogins, gotLookups int32
+	vaultSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/approle/login":
+			atomic.AddInt32(&gotLogins, 1)
+			json.NewEncoder(w).Encode(api.Secret{
+				Auth: &api.SecretAuth{ClientToken: "new-vault-token", LeaseDuration: 3600},
+			})
+		case "/v1/auth/token/lookup-self":
+			atomic.AddInt32(&gotLookups, 1)
+			json.NewEncoder(w).Encode(api.Secret{Data: map[string]interface{}{"ttl": 299}})
+		default:
+			json.NewEncoder(w).Encode(api.Secret{Data: map[string]interface{}{"my-sec": "123"}})
+		}
+	}))
+	defer vaultSvr.Close()
+
+	// the cached token is within the Client's refresh threshold, but the cache
+	// will most likely keep handing it out for a while longer
+	cache := &tokenCacheStore{token: &Token{
+		Token:   "cached-vault-token",
+		Expires: time.Now().Add(299 * time.Second),
+	}}
+	c, err := NewClient(context.Background(), Config{
+		VaultAddress:                  vaultSvr.URL,
+		AuthType:                      AuthTypeAppRole,
+		AppRoleID:                     "my-role-id",
+		TokenCache:                    cache,
+		TokenCacheRefreshThreshold:    300,
+		TokenCacheRefreshRandomOffset: 299,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer c.Close()
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.GetSecrets(context.Background(), "my-secret-path"); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	if c.token.Token != "new-vault-token" {
+		t.Errorf("expected the Client to log in for a new token, got %q", c.token.Token)
+	}
+	if gotLogins != 1 {
+		t.Errorf("expected 1 login, got %d", gotLogins)
+	}
+	if gotLookups > 2 {
+		t.Errorf("expected the cached token to be looked up at most twice, got %d", gotLookups)
+	}
+}
+
+func TestClientNamespaces(t *testing.T) {
+	gotNamespaces := map[string]string{}
+	vaultSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotNamespaces[r.URL.Path] = r.Header.Get("X-Vault-Namespace")
+		switch r.URL.Path {
+		case "/v1/auth/approle/login":
+			json.NewEncoder(w).Encode(api.Secret{
+				Auth: &api.SecretAuth{ClientToken: "vault-test-token"},
+			})
+		default:
+			json.NewEncoder(w).Encode(api.Secret{Data: map[string]interface{}{"my-sec": "123"}})
+		}
+	}))
+	defer vaultSvr.Close()
+
+	cfg := Config{
+		VaultAddress:  vaultSvr.URL,
+		Namespace:     "admin/team",
+		AuthNamespace: "admin",
+		AuthType:      AuthTypeAppRole,
+		AppRoleID:     "my-role-id",
+		SecretPath:    "secret/my-secret",
+	}
+	if _, err := GetSecrets(context.Background(), cfg); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := map[string]string{
+		"/v1/auth/approle/login": "admin",
+		"/v1/secret/my-secret":   "admin/team",
+	}
+	if !cmp.Equal(want, gotNamespaces) {
+		t.Errorf("namespaces differ: (-want +got)\n%s", cmp.Diff(want, gotNamespaces))
+	}
+
+	if err := checkDefaults(&cfg); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got := tokenCacheKey(&cfg); got != "token-cache/admin" {
+		t.Errorf("expected token cache key %q, got %q", "token-cache/admin", got)
+	}
+}
@@ -0,0 +1,305 @@
+package gcpvault
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// GetSecretsInto reads the secrets under the configured SecretPath and decodes them
+// into dst, which must be a pointer to a struct. See DecodeSecrets for how fields
+// are matched and converted.
+func GetSecretsInto(ctx context.Context, cfg Config, dst interface{}) error {
+	c, err := newOneShotClient(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	return c.GetSecretsInto(ctx, c.cfg.SecretPath, dst)
+}
+
+// GetSecretsInto reads the secrets stored at the given path and decodes them into
+// dst, which must be a pointer to a struct. See DecodeSecrets for how fields are
+// matched and converted.
+func (c *Client) GetSecretsInto(ctx context.Context, path string, dst interface{}) error {
+	secrets, err := c.GetSecrets(ctx, path)
+	if err != nil {
+		return err
+	}
+	return DecodeSecrets(secrets, dst)
+}
+
+// DecodeSecrets decodes a map of secrets, as returned by GetSecrets, into dst, which
+// must be a pointer to a struct.
+//
+// Fields are matched to secret keys using the `vault` struct tag, falling back to
+// the field name when no tag is given. A tag of "-" skips the field, and a
+// ",required" option reports an error when the key is missing:
+//
+//	type Secrets struct {
+//		APIKey  string        `vault:"APIKey,required"`
+//		Port    int           `vault:"port"`
+//		Timeout time.Duration `vault:"timeout"`
+//		Cert    []byte        `vault:"cert"`
+//		DB      struct {
+//			User     string `vault:"user"`
+//			Password string `vault:"password"`
+//		} `vault:"db"`
+//	}
+//
+// Strings are converted to numbers, bools and time.Durations as needed, []byte
+// fields expect base64 encoded strings and nested structs are decoded from nested
+// maps. Every missing or mistyped field is reported in a single *DecodeError.
+func DecodeSecrets(secrets map[string]interface{}, dst interface{}) error {
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("decode destination must be a non-nil pointer to a struct")
+	}
+
+	var derr DecodeError
+	decodeStruct(rv.Elem(), secrets, "", &derr)
+	if len(derr.Fields) > 0 {
+		return &derr
+	}
+	return nil
+}
+
+// FieldError describes a single secret that could not be decoded.
+type FieldError struct {
+	// Field is the dotted path of secret keys leading to the field.
+	Field string
+	Err   error
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Err.Error()
+}
+
+// DecodeError is returned by DecodeSecrets and lists every field that could not be
+// decoded.
+type DecodeError struct {
+	Fields []FieldError
+}
+
+func (e *DecodeError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = f.Error()
+	}
+	return "unable to decode secrets: " + strings.Join(msgs, "; ")
+}
+
+func (e *DecodeError) add(field string, err error) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Err: err})
+}
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	bytesType    = reflect.TypeOf([]byte(nil))
+)
+
+func decodeStruct(v reflect.Value, secrets map[string]interface{}, prefix string, derr *DecodeError) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			// unexported
+			continue
+		}
+
+		name, required := f.Name, false
+		if tag, ok := f.Tag.Lookup("vault"); ok {
+			parts := strings.Split(tag, ",")
+			if parts[0] == "-" {
+				continue
+			}
+			if parts[0] != "" {
+				name = parts[0]
+			}
+			for _, opt := range parts[1:] {
+				if opt == "required" {
+					required = true
+				}
+			}
+		}
+
+		field := prefix + name
+		raw, ok := secrets[name]
+		if !ok || raw == nil {
+			if required {
+				derr.add(field, errors.New("not found"))
+			}
+			if f.Type.Kind() == reflect.Struct {
+				// the fields of a missing struct may be required as well
+				decodeStruct(v.Field(i), map[string]interface{}{}, field+".", derr)
+			}
+			continue
+		}
+		decodeValue(v.Field(i), raw, field, derr)
+	}
+}
+
+func decodeValue(v reflect.Value, raw interface{}, field string, derr *DecodeError) {
+	if raw == nil {
+		return
+	}
+
+	switch {
+	case v.Type() == durationType:
+		d, err := toDuration(raw)
+		if err != nil {
+			derr.add(field, err)
+			return
+		}
+		v.SetInt(int64(d))
+		return
+	case v.Type() == bytesType:
+		s, ok := raw.(string)
+		if !ok {
+			derr.add(field, errors.Errorf("expected a base64 string, got %T", raw))
+			return
+		}
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			derr.add(field, errors.Wrap(err, "invalid base64"))
+			return
+		}
+		v.SetBytes(b)
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		elem := reflect.New(v.Type().Elem())
+		before := len(derr.Fields)
+		decodeValue(elem.Elem(), raw, field, derr)
+		if len(derr.Fields) == before {
+			v.Set(elem)
+		}
+	case reflect.Interface:
+		rv := reflect.ValueOf(raw)
+		if !rv.Type().AssignableTo(v.Type()) {
+			derr.add(field, errors.Errorf("cannot assign %T", raw))
+			return
+		}
+		v.Set(rv)
+	case reflect.String:
+		switch r := raw.(type) {
+		case string:
+			v.SetString(r)
+		case json.Number:
+			v.SetString(r.String())
+		default:
+			derr.add(field, errors.Errorf("expected a string, got %T", raw))
+		}
+	case reflect.Bool:
+		b, err := toBool(raw)
+		if err != nil {
+			derr.add(field, err)
+			return
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(numberString(raw), 10, v.Type().Bits())
+		if err != nil {
+			derr.add(field, errors.Errorf("cannot convert %v to %s", raw, v.Type()))
+			return
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(numberString(raw), 10, v.Type().Bits())
+		if err != nil {
+			derr.add(field, errors.Errorf("cannot convert %v to %s", raw, v.Type()))
+			return
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(numberString(raw), v.Type().Bits())
+		if err != nil {
+			derr.add(field, errors.Errorf("cannot convert %v to %s", raw, v.Type()))
+			return
+		}
+		v.SetFloat(n)
+	case reflect.Struct:
+		m, ok := raw.(map[string]interface{})
+		if !ok {
+			derr.add(field, errors.Errorf("expected a map, got %T", raw))
+			return
+		}
+		decodeStruct(v, m, field+".", derr)
+	case reflect.Map:
+		m, ok := raw.(map[string]interface{})
+		if !ok || v.Type().Key().Kind() != reflect.String {
+			derr.add(field, errors.Errorf("cannot convert %T to %s", raw, v.Type()))
+			return
+		}
+		out := reflect.MakeMapWithSize(v.Type(), len(m))
+		for k, mv := range m {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			decodeValue(elem, mv, field+"."+k, derr)
+			out.SetMapIndex(reflect.ValueOf(k).Convert(v.Type().Key()), elem)
+		}
+		v.Set(out)
+	case reflect.Slice:
+		s, ok := raw.([]interface{})
+		if !ok {
+			derr.add(field, errors.Errorf("expected a list, got %T", raw))
+			return
+		}
+		out := reflect.MakeSlice(v.Type(), len(s), len(s))
+		for i, sv := range s {
+			decodeValue(out.Index(i), sv, fmt.Sprintf("%s[%d]", field, i), derr)
+		}
+		v.Set(out)
+	default:
+		derr.add(field, errors.Errorf("unsupported field type %s", v.Type()))
+	}
+}
+
+func numberString(raw interface{}) string {
+	switch r := raw.(type) {
+	case string:
+		return strings.TrimSpace(r)
+	case json.Number:
+		return r.String()
+	case float64:
+		return strconv.FormatFloat(r, 'f', -1, 64)
+	default:
+		return fmt.Sprint(raw)
+	}
+}
+
+func toBool(raw interface{}) (bool, error) {
+	switch r := raw.(type) {
+	case bool:
+		return r, nil
+	case string:
+		b, err := strconv.ParseBool(strings.TrimSpace(r))
+		if err != nil {
+			return false, errors.Errorf("cannot convert %q to bool", r)
+		}
+		return b, nil
+	default:
+		return false, errors.Errorf("expected a bool, got %T", raw)
+	}
+}
+
+// toDuration accepts duration strings like "1m30s" as well as plain numbers, which
+// are treated as seconds to match how Vault reports TTLs.
+func toDuration(raw interface{}) (time.Duration, error) {
+	s := numberString(raw)
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Duration(n) * time.Second, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, errors.Errorf("cannot convert %v to time.Duration", raw)
+	}
+	return d, nil
+}
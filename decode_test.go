@@ -0,0 +1,134 @@
+package gcpvault
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type testDecodeSecrets struct {
+	APIKey  string        `vault:"APIKey,required"`
+	Port    int           `vault:"port"`
+	Debug   bool          `vault:"debug"`
+	Timeout time.Duration `vault:"timeout"`
+	Cert    []byte        `vault:"cert"`
+	Ignored string        `vault:"-"`
+	DB      struct {
+		User     string `vault:"user,required"`
+		Password string `vault:"password,required"`
+	} `vault:"db"`
+	Extra map[string]string `vault:"extra"`
+}
+
+func TestDecodeSecrets(t *testing.T) {
+	tests := []struct {
+		name         string
+		givenSecrets map[string]interface{}
+
+		wantErrFields []string
+		wantSecrets   testDecodeSecrets
+	}{
+		{
+			name: "success",
+			givenSecrets: map[string]interface{}{
+				"APIKey":  "abcd",
+				"port":    "8080",
+				"debug":   "true",
+				"timeout": "1m30s",
+				"cert":    "aGVsbG8=",
+				"Ignored": "nope",
+				"db": map[string]interface{}{
+					"user":     "jp",
+					"password": "hunter2",
+				},
+				"extra": map[string]interface{}{"region": "us-east1"},
+			},
+			wantSecrets: func() testDecodeSecrets {
+				s := testDecodeSecrets{
+					APIKey:  "abcd",
+					Port:    8080,
+					Debug:   true,
+					Timeout: 90 * time.Second,
+					Cert:    []byte("hello"),
+					Extra:   map[string]string{"region": "us-east1"},
+				}
+				s.DB.User = "jp"
+				s.DB.Password = "hunter2"
+				return s
+			}(),
+		},
+		{
+			name: "numbers from vault",
+			givenSecrets: map[string]interface{}{
+				"APIKey":  "abcd",
+				"port":    json.Number("8080"),
+				"timeout": json.Number("30"),
+				"db": map[string]interface{}{
+					"user":     "jp",
+					"password": "hunter2",
+				},
+			},
+			wantSecrets: func() testDecodeSecrets {
+				s := testDecodeSecrets{
+					APIKey:  "abcd",
+					Port:    8080,
+					Timeout: 30 * time.Second,
+				}
+				s.DB.User = "jp"
+				s.DB.Password = "hunter2"
+				return s
+			}(),
+		},
+		{
+			name: "missing and mistyped",
+			givenSecrets: map[string]interface{}{
+				"port":  "eighty",
+				"debug": "maybe",
+				"cert":  "%%%",
+				"db": map[string]interface{}{
+					"user": "jp",
+				},
+			},
+			wantErrFields: []string{"APIKey", "port", "debug", "cert", "db.password"},
+		},
+		{
+			name: "missing nested struct",
+			givenSecrets: map[string]interface{}{
+				"APIKey": "abcd",
+			},
+			wantErrFields: []string{"db.user", "db.password"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got testDecodeSecrets
+			err := DecodeSecrets(test.givenSecrets, &got)
+
+			if len(test.wantErrFields) > 0 {
+				var derr *DecodeError
+				if !errors.As(err, &derr) {
+					t.Fatalf("expected a DecodeError, got %v", err)
+				}
+				var gotFields []string
+				for _, f := range derr.Fields {
+					gotFields = append(gotFields, f.Field)
+				}
+				if !cmp.Equal(test.wantErrFields, gotFields) {
+					t.Errorf("error fields differ: (-want +got)\n%s", cmp.Diff(test.wantErrFields, gotFields))
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("expected no error, got %s", err)
+			}
+			if !cmp.Equal(test.wantSecrets, got) {
+				t.Errorf("secrets differ: (-want +got)\n%s", cmp.Diff(test.wantSecrets, got))
+			}
+		})
+	}
+}
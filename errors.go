@@ -0,0 +1,90 @@
+package gcpvault
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// Errors that failures from this package can be matched against with errors.Is.
+var (
+	// ErrSecretNotFound means nothing is stored at the requested path.
+	ErrSecretNotFound = errors.New("secret not found")
+	// ErrPermissionDenied means Vault refused the request, usually because the
+	// token's policies do not allow it or a login was rejected.
+	ErrPermissionDenied = errors.New("permission denied")
+	// ErrVaultUnavailable means Vault could not be reached or could not serve the
+	// request, for example because it is sealed or rate limiting.
+	ErrVaultUnavailable = errors.New("vault is unavailable")
+	// ErrTokenCache means the TokenCache could not be read from or written to.
+	ErrTokenCache = errors.New("token cache failure")
+	// ErrJWTSigning means the JWT used to log in with GCP auth could not be created.
+	ErrJWTSigning = errors.New("unable to sign JWT")
+)
+
+// Error is returned for failures that match one of the package's Err values. The
+// error it wraps, such as an *api.ResponseError holding the status code and the
+// messages returned by Vault, remains available with errors.As.
+type Error struct {
+	// Kind is the Err value this error matches.
+	Kind error
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the error's Kind.
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+// newError annotates err with message and marks it as being of the given kind.
+func newError(kind, err error, message string) error {
+	return &Error{Kind: kind, Err: errors.Wrap(err, message)}
+}
+
+// wrapVaultError annotates an error returned by the Vault client with message and,
+// if the cause is recognized, marks it with one of the Err values.
+func wrapVaultError(err error, message string) error {
+	if err == nil {
+		return nil
+	}
+	kind := vaultErrorKind(err)
+	if kind == nil {
+		return errors.Wrap(err, message)
+	}
+	return newError(kind, err, message)
+}
+
+func vaultErrorKind(err error) error {
+	var respErr *api.ResponseError
+	if errors.As(err, &respErr) {
+		switch {
+		case respErr.StatusCode == http.StatusNotFound:
+			return ErrSecretNotFound
+		case respErr.StatusCode == http.StatusUnauthorized,
+			respErr.StatusCode == http.StatusForbidden:
+			return ErrPermissionDenied
+		case respErr.StatusCode == http.StatusTooManyRequests,
+			respErr.StatusCode >= http.StatusInternalServerError:
+			return ErrVaultUnavailable
+		}
+		return nil
+	}
+	if errors.Is(err, api.ErrSecretNotFound) {
+		return ErrSecretNotFound
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return ErrVaultUnavailable
+	}
+	return nil
+}
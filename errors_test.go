@@ -0,0 +1,109 @@
+package gcpvault
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/hashicorp/vault/api"
+)
+
+func TestGetSecretsErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		givenStatus int
+		givenErrors []string
+
+		wantKind       error
+		wantVaultError []string
+	}{
+		{
+			name:        "not found",
+			givenStatus: http.StatusNotFound,
+			givenErrors: []string{},
+
+			wantKind: ErrSecretNotFound,
+		},
+		{
+			name:        "permission denied",
+			givenStatus: http.StatusForbidden,
+			givenErrors: []string{"1 error occurred:\n\t* permission denied\n\n"},
+
+			wantKind:       ErrPermissionDenied,
+			wantVaultError: []string{"1 error occurred:\n\t* permission denied\n\n"},
+		},
+		{
+			name:        "sealed",
+			givenStatus: http.StatusServiceUnavailable,
+			givenErrors: []string{"Vault is sealed"},
+
+			wantKind:       ErrVaultUnavailable,
+			wantVaultError: []string{"Vault is sealed"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vaultSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.givenStatus)
+				json.NewEncoder(w).Encode(map[string]interface{}{"errors": test.givenErrors})
+			}))
+			defer vaultSvr.Close()
+
+			_, err := GetSecrets(context.Background(), Config{
+				VaultAddress: vaultSvr.URL,
+				LocalToken:   "my-local-token",
+				SecretPath:   "my-secret-path",
+				MaxRetries:   1,
+			})
+			if !errors.Is(err, test.wantKind) {
+				t.Fatalf("expected error to match %q, got %v", test.wantKind, err)
+			}
+			if test.wantVaultError == nil {
+				return
+			}
+			var respErr *api.ResponseError
+			if !errors.As(err, &respErr) {
+				t.Fatalf("expected the *api.ResponseError to be preserved, got %v", err)
+			}
+			if respErr.StatusCode != test.givenStatus {
+				t.Errorf("expected status %d, got %d", test.givenStatus, respErr.StatusCode)
+			}
+			if !cmp.Equal(test.wantVaultError, respErr.Errors) {
+				t.Errorf("vault errors differ: (-want +got)\n%s", cmp.Diff(test.wantVaultError, respErr.Errors))
+			}
+		})
+	}
+}
+
+func TestLoginErrors(t *testing.T) {
+	cache := errTokenCache{err: errors.New("redis is down")}
+	_, err := GetSecrets(context.Background(), Config{
+		VaultAddress: "http://127.0.0.1:0",
+		SecretPath:   "my-secret-path",
+		TokenCache:   cache,
+		MaxRetries:   1,
+	})
+	if !errors.Is(err, ErrTokenCache) {
+		t.Errorf("expected error to match %q, got %v", ErrTokenCache, err)
+	}
+	if !errors.Is(err, cache.err) {
+		t.Errorf("expected the cache's error to be preserved, got %v", err)
+	}
+}
+
+type errTokenCache struct {
+	err error
+}
+
+func (c errTokenCache) GetToken(ctx context.Context) (*Token, error) {
+	return nil, c.err
+}
+
+func (c errTokenCache) SaveToken(ctx context.Context, token Token) error {
+	return c.err
+}
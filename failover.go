@@ -0,0 +1,258 @@
+package gcpvault
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// healthCheckTimeout bounds each sys/health request made while choosing an address.
+const healthCheckTimeout = 5 * time.Second
+
+// vaultAddrs tracks which of several Vault addresses requests are sent to. It is
+// shared by every Vault client in the process created with the same addresses, so
+// they all stick to the same cluster.
+type vaultAddrs struct {
+	addrs []*url.URL
+
+	mu       sync.Mutex
+	current  int
+	selected bool
+}
+
+// vaultAddrsByList holds the vaultAddrs for each list of addresses, the way
+// memoryTokens holds tokens, so package level calls do not choose an address anew.
+var vaultAddrsByList = struct {
+	sync.Mutex
+	m map[string]*vaultAddrs
+}{m: map[string]*vaultAddrs{}}
+
+// newVaultAddrs returns the failover state for the addresses in cfg, creating it
+// the first time they are used.
+func newVaultAddrs(cfg Config) (*vaultAddrs, error) {
+	var raw []string
+	if cfg.VaultAddress != "" {
+		raw = append(raw, cfg.VaultAddress)
+	}
+	for _, addr := range cfg.VaultAddresses {
+		if addr != cfg.VaultAddress {
+			raw = append(raw, addr)
+		}
+	}
+
+	v := &vaultAddrs{}
+	for _, addr := range raw {
+		u, err := url.Parse(strings.TrimSuffix(addr, "/"))
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, errors.Errorf("invalid vault address %q", addr)
+		}
+		v.addrs = append(v.addrs, u)
+	}
+
+	key := strings.Join(raw, ",")
+	vaultAddrsByList.Lock()
+	defer vaultAddrsByList.Unlock()
+	if shared, ok := vaultAddrsByList.m[key]; ok {
+		return shared, nil
+	}
+	vaultAddrsByList.m[key] = v
+	return v, nil
+}
+
+// active returns the address requests are currently sent to, picking the first
+// healthy address if none has been chosen yet. The health checks are made without
+// holding the lock, so they do not hold up requests from other goroutines.
+func (v *vaultAddrs) active(ctx context.Context, rt http.RoundTripper) string {
+	v.mu.Lock()
+	selected, current := v.selected, v.current
+	v.mu.Unlock()
+	if selected {
+		return v.addrs[current].String()
+	}
+
+	chosen := 0
+	for i, u := range v.addrs {
+		if healthy(ctx, rt, u) {
+			chosen = i
+			break
+		}
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	// another request may have chosen an address or failed over in the meantime
+	if !v.selected {
+		v.selected = true
+		v.current = chosen
+	}
+	return v.addrs[v.current].String()
+}
+
+// failover moves away from the address at index from, if requests are still being
+// sent to it, to the next healthy address. It returns the index to try next and
+// false once every address has been tried since start. Like active, it checks the
+// other addresses without holding the lock.
+func (v *vaultAddrs) failover(ctx context.Context, rt http.RoundTripper, start, from int) (int, bool) {
+	v.mu.Lock()
+	current := v.current
+	v.mu.Unlock()
+	if current != from {
+		// another request already failed over
+		return current, current != start
+	}
+
+	for i := (from + 1) % len(v.addrs); i != start; i = (i + 1) % len(v.addrs) {
+		if !healthy(ctx, rt, v.addrs[i]) {
+			continue
+		}
+		v.mu.Lock()
+		defer v.mu.Unlock()
+		if v.current != from {
+			return v.current, v.current != start
+		}
+		v.current = i
+		return i, true
+	}
+	return from, false
+}
+
+// healthy reports whether the Vault at u is initialized, unsealed and able to serve
+// requests, either as the active node or as a standby.
+// https://www.vaultproject.io/api-docs/system/health
+func healthy(ctx context.Context, rt http.RoundTripper, u *url.URL) bool {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		u.String()+"/v1/sys/health?standbyok=true&perfstandbyok=true", nil)
+	if err != nil {
+		return false
+	}
+	resp, err := rt.RoundTrip(r)
+	if err != nil {
+		return false
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+// failoverTransport sends requests to the active Vault address and fails over to
+// the next healthy one on connection errors and 5xx responses. Writes, including
+// logins, only fail over on responses that show the cluster did not take them, as
+// repeating a write it applied before failing could make a check-and-set fail.
+type failoverTransport struct {
+	addrs *vaultAddrs
+	base  http.RoundTripper
+}
+
+func (t *failoverTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.URL.Host != t.addrs.addrs[0].Host {
+		// a redirect Vault sent us to a specific node
+		return t.base.RoundTrip(r)
+	}
+
+	var body []byte
+	if r.Body != nil && r.Body != http.NoBody {
+		var err error
+		body, err = ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	t.addrs.active(r.Context(), t.base)
+	t.addrs.mu.Lock()
+	start := t.addrs.current
+	t.addrs.mu.Unlock()
+
+	i := start
+	for attempt := 1; ; attempt++ {
+		req := r.Clone(r.Context())
+		req.URL.Scheme = t.addrs.addrs[i].Scheme
+		req.URL.Host = t.addrs.addrs[i].Host
+		req.Host = ""
+		if body != nil {
+			req.Body = ioutil.NopCloser(bytes.NewReader(body))
+			req.ContentLength = int64(len(body))
+		}
+
+		resp, err := t.base.RoundTrip(req)
+		if err == nil && resp.StatusCode < http.StatusInternalServerError {
+			return resp, nil
+		}
+		if r.Context().Err() != nil {
+			return resp, err
+		}
+		if err == nil && !retriable(r.Method, resp.StatusCode) {
+			return resp, nil
+		}
+
+		next, ok := t.addrs.failover(r.Context(), t.base, start, i)
+		if !ok || attempt >= len(t.addrs.addrs) {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		i = next
+	}
+}
+
+// retriable reports whether a request with the given method that got a 5xx status
+// can be sent to another cluster. Reads can always be repeated, while writes are
+// only repeated when the cluster was unable to pass them on or is sealed.
+func retriable(method string, status int) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, "LIST":
+		return true
+	}
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable
+}
+
+// vaultHTTPClient returns the HTTP client used to talk to Vault, failing over
+// between addresses when VaultAddresses is set.
+func vaultHTTPClient(ctx context.Context, cfg Config) (*http.Client, error) {
+	hc, err := getVaultHTTPClient(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.vaultAddrs == nil || len(cfg.vaultAddrs.addrs) < 2 {
+		return hc, nil
+	}
+	base := hc.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &http.Client{
+		Transport:     &failoverTransport{addrs: cfg.vaultAddrs, base: base},
+		CheckRedirect: hc.CheckRedirect,
+		Jar:           hc.Jar,
+		Timeout:       hc.Timeout,
+	}, nil
+}
+
+// activeVaultAddress is the address of the Vault cluster requests are sent to.
+func activeVaultAddress(ctx context.Context, cfg Config) string {
+	if cfg.vaultAddrs == nil || len(cfg.vaultAddrs.addrs) < 2 {
+		return cfg.VaultAddress
+	}
+	hc, err := getVaultHTTPClient(ctx, cfg)
+	if err != nil {
+		return cfg.VaultAddress
+	}
+	base := hc.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return cfg.vaultAddrs.active(ctx, base)
+}
@@ -0,0 +1,321 @@
+package gcpvault
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/hashicorp/vault/api"
+)
+
+func TestVaultFailover(t *testing.T) {
+	tests := []struct {
+		name          string
+		givenSealed   bool
+		givenFailing  bool
+		givenDown     bool
+		givenRequests int
+
+		wantPrimaryHits int
+		wantAddress     string
+	}{
+		{
+			name:          "primary healthy",
+			givenRequests: 2,
+
+			wantPrimaryHits: 3,
+			wantAddress:     "primary",
+		},
+		{
+			name:          "primary sealed at startup",
+			givenSealed:   true,
+			givenRequests: 2,
+
+			wantAddress: "secondary",
+		},
+		{
+			name:          "primary fails requests",
+			givenFailing:  true,
+			givenRequests: 2,
+
+			// the login fails over and everything after sticks to the secondary
+			wantPrimaryHits: 1,
+			wantAddress:     "secondary",
+		},
+		{
+			name:          "primary down",
+			givenDown:     true,
+			givenRequests: 2,
+
+			wantAddress: "secondary",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				mu   sync.Mutex
+				hits = map[string]int{}
+			)
+			newServer := func(name string, sealed, failing bool) *httptest.Server {
+				return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if r.URL.Path == "/v1/sys/health" {
+						if sealed {
+							w.WriteHeader(http.StatusServiceUnavailable)
+						}
+						return
+					}
+					mu.Lock()
+					hits[name]++
+					mu.Unlock()
+					if failing {
+						w.WriteHeader(http.StatusBadGateway)
+						return
+					}
+					if r.URL.Path == "/v1/auth/approle/login" {
+						json.NewEncoder(w).Encode(api.Secret{
+							Auth: &api.SecretAuth{ClientToken: name + "-token", LeaseDuration: 3600},
+						})
+						return
+					}
+					json.NewEncoder(w).Encode(api.Secret{Data: map[string]interface{}{"cluster": name}})
+				}))
+			}
+			primary := newServer("primary", test.givenSealed, test.givenFailing)
+			secondary := newServer("secondary", false, false)
+			defer secondary.Close()
+			if test.givenDown {
+				primary.Close()
+			} else {
+				defer primary.Close()
+			}
+			addrs := map[string]string{"primary": primary.URL, "secondary": secondary.URL}
+
+			c, err := NewClient(context.Background(), Config{
+				VaultAddresses: []string{primary.URL, secondary.URL},
+				AuthType:       AuthTypeAppRole,
+				AppRoleID:      "my-role-id",
+				MaxRetries:     1,
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			defer c.Close()
+
+			for i := 0; i < test.givenRequests; i++ {
+				secrets, err := c.GetSecrets(context.Background(), "my-secret-path")
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				want := map[string]interface{}{"cluster": test.wantAddress}
+				if !cmp.Equal(want, secrets) {
+					t.Errorf("secrets differ: (-want +got)\n%s", cmp.Diff(want, secrets))
+				}
+			}
+
+			if hits["primary"] != test.wantPrimaryHits {
+				t.Errorf("expected %d requests to the primary, got %d", test.wantPrimaryHits, hits["primary"])
+			}
+			if c.token.Address != addrs[test.wantAddress] {
+				t.Errorf("expected token to be issued by %s, got %s", addrs[test.wantAddress], c.token.Address)
+			}
+		})
+	}
+}
+
+func TestCachedTokenFromOtherCluster(t *testing.T) {
+	var gotLogin bool
+	vaultSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/sys/health":
+		case "/v1/auth/approle/login":
+			gotLogin = true
+			json.NewEncoder(w).Encode(api.Secret{
+				Auth: &api.SecretAuth{ClientToken: "new-token", LeaseDuration: 3600},
+			})
+		default:
+			json.NewEncoder(w).Encode(api.Secret{Data: map[string]interface{}{"ttl": 3600}})
+		}
+	}))
+	defer vaultSvr.Close()
+	other := httptest.NewServer(http.NotFoundHandler())
+	defer other.Close()
+
+	c, err := NewClient(context.Background(), Config{
+		VaultAddresses: []string{vaultSvr.URL, other.URL},
+		AuthType:       AuthTypeAppRole,
+		AppRoleID:      "my-role-id",
+		TokenCache: TokenCacheMock{Token{
+			Token:   "other-cluster-token",
+			Expires: time.Now().Add(time.Hour),
+			Address: other.URL,
+		}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer c.Close()
+
+	if !gotLogin {
+		t.Error("expected a new login instead of the other cluster's token")
+	}
+	if c.token.Token != "new-token" || c.token.Address != vaultSvr.URL {
+		t.Errorf("unexpected token: %+v", c.token)
+	}
+}
+
+func TestFailoverDoesNotBlockRequests(t *testing.T) {
+	release := make(chan struct{})
+	slowSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slowSvr.Close()
+	defer close(release)
+
+	addrs, err := newVaultAddrs(Config{
+		VaultAddress:   "http://primary.example.com",
+		VaultAddresses: []string{slowSvr.URL},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	addrs.selected = true
+
+	// health checking the secondary hangs
+	go addrs.failover(context.Background(), http.DefaultTransport, 0, 0)
+	time.Sleep(50 * time.Millisecond)
+
+	got := make(chan string, 1)
+	go func() { got <- addrs.active(context.Background(), http.DefaultTransport) }()
+	select {
+	case addr := <-got:
+		if addr != "http://primary.example.com" {
+			t.Errorf("expected the primary address, got %q", addr)
+		}
+	case <-time.After(time.Second):
+		t.Error("expected active not to wait for the health check")
+	}
+}
+
+func TestFailoverWrites(t *testing.T) {
+	tests := []struct {
+		name        string
+		givenMethod string
+		givenStatus int
+
+		wantStatus int
+	}{
+		{
+			name:        "read on internal error",
+			givenMethod: http.MethodGet,
+			givenStatus: http.StatusInternalServerError,
+
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:        "write on internal error",
+			givenMethod: http.MethodPut,
+			givenStatus: http.StatusInternalServerError,
+
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name:        "write on gateway timeout",
+			givenMethod: http.MethodPost,
+			givenStatus: http.StatusGatewayTimeout,
+
+			wantStatus: http.StatusGatewayTimeout,
+		},
+		{
+			name:        "write to a sealed cluster",
+			givenMethod: http.MethodPut,
+			givenStatus: http.StatusServiceUnavailable,
+
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/v1/sys/health" {
+					w.WriteHeader(test.givenStatus)
+				}
+			}))
+			defer primary.Close()
+			secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			defer secondary.Close()
+
+			addrs, err := newVaultAddrs(Config{
+				VaultAddress:   primary.URL,
+				VaultAddresses: []string{secondary.URL},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			rt := &failoverTransport{addrs: addrs, base: http.DefaultTransport}
+
+			r, _ := http.NewRequest(test.givenMethod, primary.URL+"/v1/secret/my-secret-path", strings.NewReader(`{"my-sec":"123"}`))
+			resp, err := rt.RoundTrip(r)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != test.wantStatus {
+				t.Errorf("expected status %d, got %d", test.wantStatus, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestVaultFailoverSharedAcrossCalls(t *testing.T) {
+	var (
+		mu         sync.Mutex
+		gotHealths int
+	)
+	newServer := func() *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/v1/sys/health":
+				mu.Lock()
+				gotHealths++
+				mu.Unlock()
+			case "/v1/auth/approle/login":
+				json.NewEncoder(w).Encode(api.Secret{
+					Auth: &api.SecretAuth{ClientToken: "vault-test-token", LeaseDuration: 3600},
+				})
+			default:
+				json.NewEncoder(w).Encode(api.Secret{Data: map[string]interface{}{"my-sec": "123"}})
+			}
+		}))
+	}
+	primary, secondary := newServer(), newServer()
+	defer primary.Close()
+	defer secondary.Close()
+
+	cfg := Config{
+		VaultAddresses: []string{primary.URL, secondary.URL},
+		AuthType:       AuthTypeAppRole,
+		AppRoleID:      "my-role-id",
+		SecretPath:     "my-secret-path",
+	}
+	if _, err := GetSecrets(context.Background(), cfg); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	mu.Lock()
+	wantHealths := gotHealths
+	mu.Unlock()
+
+	if _, err := GetSecrets(context.Background(), cfg); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if gotHealths != wantHealths {
+		t.Errorf("expected no more health checks after the first call, got %d", gotHealths-wantHealths)
+	}
+}
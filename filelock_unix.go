@@ -0,0 +1,40 @@
+// +build darwin dragonfly freebsd linux netbsd openbsd
+// +build !appengine
+
+package gcpvault
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// tryLockFile takes an exclusive advisory lock on the file at path without waiting,
+// reporting false if another process holds it.
+func tryLockFile(path string) (func(), bool, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, false, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, false, err
+	}
+	for {
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err != syscall.EINTR {
+			break
+		}
+	}
+	if err == syscall.EWOULDBLOCK {
+		f.Close()
+		return nil, false, nil
+	}
+	if err != nil {
+		f.Close()
+		return nil, false, err
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, true, nil
+}
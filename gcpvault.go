@@ -24,15 +24,105 @@ type Config struct {
 	// SecretPath is the location of the secrets we wish to fetch from Vault.
 	SecretPath string `envconfig:"VAULT_SECRET_PATH"`
 
+	// KVMount is the path a KV v2 secrets engine is mounted at, such as 'secret'.
+	// When set, functions like GetKVSecret treat SecretPath as a key within this mount
+	// and add the '/data/' and '/metadata/' path segments themselves.
+	KVMount string `envconfig:"VAULT_KV_MOUNT"`
+
 	// VaultAddress is the location of the Vault server.
 	VaultAddress string `envconfig:"VAULT_ADDR"`
 
+	// VaultAddresses is an ordered list of additional Vault addresses, such as
+	// performance standbys or DR replicas, to fail over to. The first healthy
+	// address, starting with VaultAddress, is used until a request to it fails with
+	// a connection error or a 5xx response, at which point requests move on to the
+	// next healthy address.
+	VaultAddresses []string `envconfig:"VAULT_ADDRS"`
+
+	// TLSCACert is the path to a PEM encoded CA certificate used to verify the Vault
+	// server's certificate. TLSCACertBytes may hold the PEM itself instead. Both
+	// are added to the trusted CAs if set. Defaults to the system's CAs.
+	TLSCACert      string `envconfig:"VAULT_CACERT"`
+	TLSCACertBytes string `envconfig:"VAULT_CACERT_BYTES"`
+
+	// TLSClientCert and TLSClientKey are paths to a PEM encoded client certificate
+	// and key for mutual TLS with Vault. The files are read again when they change,
+	// so rotated certificates are used without a restart.
+	TLSClientCert string `envconfig:"VAULT_CLIENT_CERT"`
+	TLSClientKey  string `envconfig:"VAULT_CLIENT_KEY"`
+
+	// TLSServerName is the name used to verify the Vault server's certificate, if
+	// it differs from the host in VaultAddress.
+	TLSServerName string `envconfig:"VAULT_TLS_SERVER_NAME"`
+
+	// TLSMinVersion is the minimum TLS version used with Vault: 'tls10', 'tls11',
+	// 'tls12' or 'tls13'. Default is 'tls12'.
+	//
+	// The TLS settings only apply to requests made to Vault, are ignored when
+	// HTTPClient is set and are not supported on App Engine Standard.
+	TLSMinVersion string `envconfig:"VAULT_TLS_MIN_VERSION"`
+
+	// Namespace is the Vault Enterprise namespace secrets are read from and
+	// written to.
+	Namespace string `envconfig:"VAULT_NAMESPACE"`
+
+	// AuthNamespace is the Vault Enterprise namespace the auth method is mounted in,
+	// if it differs from the Namespace. The namespace is also part of the key
+	// tokens are cached under.
+	// Defaults to the Namespace.
+	AuthNamespace string `envconfig:"VAULT_AUTH_NAMESPACE"`
+
 	// Role is the role given to your service account when it was registered
 	// with your Vault server. More information about creating roles for your service
 	// account can be found here:
 	// https://www.vaultproject.io/docs/auth/gcp.html#2-roles
 	Role string `envconfig:"VAULT_GCP_IAM_ROLE"`
 
+	// RoleType is the type of the Vault role, either 'iam' or 'gce'. 'iam' roles
+	// log in with a JWT signed by the IAM credentials API, which requires the service
+	// account to hold roles/iam.serviceAccountTokenCreator on itself. 'gce' roles log
+	// in with an instance identity token from the metadata server instead, which is
+	// available on GCE, GKE and Cloud Run without any extra permissions.
+	// Defaults to 'iam'.
+	RoleType string `envconfig:"VAULT_GCP_ROLE_TYPE"`
+
+	// ImpersonateServiceAccount is the email of a service account to log in to Vault
+	// as, instead of the account behind the default credentials. The JWT is signed
+	// by the IAM credentials API on behalf of this account, which requires the default
+	// credentials to hold roles/iam.serviceAccountTokenCreator on it (or on the first
+	// of the ImpersonateDelegates). Only supported by the 'iam' role type.
+	ImpersonateServiceAccount string `envconfig:"VAULT_GCP_IMPERSONATE_SERVICE_ACCOUNT"`
+
+	// ImpersonateDelegates is an optional chain of service account emails used to
+	// reach ImpersonateServiceAccount. Each account in the chain must be able to
+	// create tokens for the next one, and the last for ImpersonateServiceAccount.
+	ImpersonateDelegates []string `envconfig:"VAULT_GCP_IMPERSONATE_DELEGATES"`
+
+	// SignLocally signs the 'iam' login JWT with the private key of the default
+	// credentials when they come from a service account key file, so no call to the
+	// IAM credentials API is made. Credentials without a key, or logins using
+	// ImpersonateServiceAccount, still sign with the IAM credentials API.
+	SignLocally bool `envconfig:"VAULT_GCP_SIGN_LOCALLY"`
+
+	// JWTExpiration is how long, in seconds, the JWT used to log in with an 'iam' role
+	// is valid for. It must not exceed the role's max_jwt_exp.
+	// Default is 300 seconds.
+	JWTExpiration int `envconfig:"VAULT_GCP_JWT_EXP"`
+
+	// JWTMaxExpiration is the longest JWTExpiration allowed, in seconds. It guards
+	// against logins that Vault would reject and should match the role's max_jwt_exp.
+	// Default is 900 seconds, the same as Vault's default max_jwt_exp.
+	JWTMaxExpiration int `envconfig:"VAULT_GCP_JWT_MAX_EXP"`
+
+	// JWTAudience is the audience of the login JWT. Vault deployments configured
+	// with a custom audience can set it here.
+	// Defaults to 'vault/' followed by the Role.
+	JWTAudience string `envconfig:"VAULT_GCP_JWT_AUDIENCE"`
+
+	// JWTClaims are additional claims added to the JWT used to log in with an 'iam'
+	// role. They may not replace the 'aud', 'sub' or 'exp' claims.
+	JWTClaims map[string]interface{} `ignored:"true"`
+
 	// LocalToken is a Vault auth token obtained from logging into Vault via some outside
 	// method like the command line tool. Users are only expected to pass this token
 	// in local development scenarios.
@@ -40,16 +130,41 @@ type Config struct {
 	// underlying Vault API client will use it.
 	LocalToken string `envconfig:"VAULT_LOCAL_TOKEN"`
 
-	// AuthPath is the path the GCP authentication method is mounted at.
-	// Defaults to 'auth/gcp'.
+	// AuthPath is the path the authentication method is mounted at.
+	// Defaults to 'auth/gcp', 'auth/kubernetes' or 'auth/approle' depending on the
+	// AuthType.
 	AuthPath string `envconfig:"VAULT_GCP_PATH"`
 
+	// AuthMethod logs in to Vault. If not set, one of the built in methods is chosen
+	// with AuthType.
+	AuthMethod AuthMethod
+
+	// AuthType selects the built in AuthMethod used when AuthMethod is not set:
+	// 'gcp', 'kubernetes', 'approle' or 'token'. The 'kubernetes' method logs in as
+	// Role with the JWT at KubernetesTokenPath, 'approle' uses AppRoleID and
+	// AppRoleSecretID and 'token' behaves like TokenAuth.
+	// Defaults to 'gcp', using the RoleType to log in.
+	AuthType string `envconfig:"VAULT_AUTH_METHOD"`
+
+	// KubernetesTokenPath is the service account JWT used by the 'kubernetes'
+	// AuthType. Defaults to KubernetesTokenPathDefault.
+	KubernetesTokenPath string `envconfig:"VAULT_KUBERNETES_TOKEN_PATH"`
+
+	// AppRoleID and AppRoleSecretID are the credentials used by the 'approle'
+	// AuthType.
+	AppRoleID       string `envconfig:"VAULT_APPROLE_ROLE_ID"`
+	AppRoleSecretID string `envconfig:"VAULT_APPROLE_SECRET_ID"`
+
 	// MaxRetries sets the number of retries that will be used in the case of certain
 	// errors. The underlying Vault client will pull this value out of the environment
 	// on it's own, but we're including it here so users can apply the same number of
 	// attempts towards signing the JWT with Google's IAM services.
 	MaxRetries int `envconfig:"VAULT_MAX_RETRIES"`
 
+	// MaxConcurrency limits how many requests are made to Vault at once by functions
+	// that read many secrets, like GetSecretTree. Default is 8.
+	MaxConcurrency int `envconfig:"VAULT_MAX_CONCURRENCY"`
+
 	// IAMAddress is the location of the GCP IAM server.
 	// This should only used for testing.
 	IAMAddress string `envconfig:"IAM_ADDR"`
@@ -63,6 +178,11 @@ type Config struct {
 	// IdleConnTimeout will be used.
 	HTTPClient *http.Client
 
+	// RenewToken enables background renewal of the Vault token held by a Client.
+	// The token is renewed while Vault allows it and a new login is made once it
+	// reaches its max TTL. Tokens are not renewed when LocalToken is set.
+	RenewToken bool `envconfig:"VAULT_RENEW_TOKEN"`
+
 	TokenCache TokenCache
 	// How long before the token expiration should it be regenerated (in seconds).
 	// Default is 300 seconds.
@@ -79,6 +199,34 @@ type Config struct {
 	TokenCacheStorageRedis string `envconfig:"TOKEN_CACHE_STORAGE_REDIS"`
 	//Database for Redis. Default is 0
 	TokenCacheStorageRedisDB int `envconfig:"TOKEN_CACHE_STORAGE_REDIS_DB"`
+	// Local directory where the token can be stored for caching purposes
+	TokenCacheStorageFile string `envconfig:"TOKEN_CACHE_STORAGE_FILE"`
+	// Has instances sharing a GCS or Redis cache take a lock before logging in when
+	// the cached token is missing or expired, so the others can wait for the token
+	// instead of all logging in at once. A file cache always does this
+	TokenCacheLock bool `envconfig:"TOKEN_CACHE_LOCK"`
+	// How long to wait for another instance to log in (in seconds) before logging in
+	// anyway. Default is 10 seconds
+	TokenCacheLockTimeout int `envconfig:"TOKEN_CACHE_LOCK_TIMEOUT"`
+	// Cloud KMS key used to encrypt tokens stored in GCS, Redis, a file or a custom
+	// TokenCache, as in
+	// 'projects/my-project/locations/global/keyRings/my-ring/cryptoKeys/my-key'
+	TokenCacheKMSKey string `envconfig:"TOKEN_CACHE_KMS_KEY"`
+	// Base64 encoded AES key used to encrypt tokens stored in GCS, Redis, a file or
+	// a custom TokenCache
+	TokenCacheEncryptionKey string `envconfig:"TOKEN_CACHE_ENCRYPTION_KEY"`
+	// Previous TokenCacheEncryptionKeys that cached tokens may still be encrypted with
+	TokenCacheDecryptionKeys []string `envconfig:"TOKEN_CACHE_DECRYPTION_KEYS"`
+	// Encrypts tokens stored in GCS, Redis, a file or a custom TokenCache, in place
+	// of the keys above
+	TokenCacheKeyWrapper KeyWrapper
+
+	// KMSAddress is the location of the Cloud KMS server.
+	// This should only used for testing.
+	KMSAddress string `envconfig:"KMS_ADDR"`
+
+	// vaultAddrs holds the failover state for VaultAddresses.
+	vaultAddrs *vaultAddrs
 }
 
 type TokenCache interface {
@@ -89,6 +237,13 @@ type TokenCache interface {
 type Token struct {
 	Token   string
 	Expires time.Time
+	// Address is the Vault address the token was issued by, when VaultAddresses
+	// is used. Cached tokens issued by another cluster are not reused.
+	Address string `json:",omitempty"`
+
+	// trusted is set for tokens kept in memory from this process' own logins,
+	// which are used without being looked up in Vault first.
+	trusted bool
 }
 
 const (
@@ -97,7 +252,14 @@ const (
 	TokenCacheRefreshRandomOffsetDefault = 60
 	TokenCacheKeyNameDefault             = "token-cache"
 	TokenCacheMaxRetriesDefault          = 3
+	TokenCacheLockTimeoutDefault         = 10
+	MaxConcurrencyDefault                = 8
+	JWTExpirationDefault                 = 300
+	JWTMaxExpirationDefault              = 900
 	CloudScope                           = "https://www.googleapis.com/auth/cloud-platform"
+
+	RoleTypeIAM = "iam"
+	RoleTypeGCE = "gce"
 )
 
 // GetSecrets will use GCP Auth to access any secrets under the given SecretPath in
@@ -121,90 +283,134 @@ const (
 //
 // If running in a local development environment (via 'goapp test' or dev_appserver.py)
 // this tool will expect the LocalToken to be set in some way.
+//
+// Each call reuses the token cached in memory by earlier calls with the same
+// address and credentials, and concurrent calls share a single login. Users making
+// many requests should still create a Client with NewClient, which also keeps the
+// Vault API client between calls.
 func GetSecrets(ctx context.Context, cfg Config) (map[string]interface{}, error) {
-	err := checkDefaults(&cfg)
+	c, err := newOneShotClient(ctx, cfg)
 	if err != nil {
 		return nil, err
 	}
-
-	vClient, err := login(ctx, cfg)
-	if err != nil {
-		return nil, errors.Wrap(err, "unable to login to vault ")
-	}
-
-	// fetch secrets
-	secrets, err := vClient.Logical().Read(cfg.SecretPath)
-	if err != nil {
-		return nil, errors.Wrap(err, "unable to get secrets")
-	}
-	if secrets == nil {
-		return nil, errors.New("no secrets found")
-	}
-	if (secrets.Data == nil || len(secrets.Data) == 0) && secrets.Warnings != nil {
-		err := errors.New(strings.Join(secrets.Warnings, ","))
-		return nil, errors.Wrap(err, "no secrets found")
-	}
-	return secrets.Data, nil
+	return c.GetSecrets(ctx, c.cfg.SecretPath)
 }
 
 // PutSecrets writes secrets to Vault at the configured path.
 // This is comparable to the `vault write` command.
 func PutSecrets(ctx context.Context, cfg Config, secrets map[string]interface{}) error {
-	err := checkDefaults(&cfg)
+	c, err := newOneShotClient(ctx, cfg)
 	if err != nil {
 		return err
 	}
-
-	vClient, err := login(ctx, cfg)
-	if err != nil {
-		return errors.Wrap(err, "unable to login to vault")
-	}
-	_, err = vClient.Logical().Write(cfg.SecretPath, secrets)
-	return errors.Wrap(err, "unable to make vault request")
+	return c.PutSecrets(ctx, c.cfg.SecretPath, secrets)
 }
 
 // GetVersionedSecrets reads versioned secrets from Vault.
 // This is comparable to the `vault kv get` command.
 func GetVersionedSecrets(ctx context.Context, cfg Config) (map[string]interface{}, error) {
-	err := checkDefaults(&cfg)
+	c, err := newOneShotClient(ctx, cfg)
 	if err != nil {
 		return nil, err
 	}
+	return c.GetVersionedSecrets(ctx, c.cfg.SecretPath)
+}
 
-	secs, err := GetSecrets(ctx, cfg)
+// PutVersionedSecrets writes versioned secrets to Vault at the configured path.
+// This is comparable to the `vault kv put` command.
+//
+// Passing WithCheckAndSet makes the write conditional on the current version of the
+// secret, returning a *CheckAndSetError if it does not match.
+func PutVersionedSecrets(ctx context.Context, cfg Config, secrets map[string]interface{}, opts ...PutOption) error {
+	c, err := newOneShotClient(ctx, cfg)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	return c.PutVersionedSecrets(ctx, c.cfg.SecretPath, secrets, opts...)
+}
+
+func readSecrets(ctx context.Context, vClient *api.Client, path string) (map[string]interface{}, error) {
+	secrets, err := vClient.Logical().ReadWithContext(ctx, path)
+	if err != nil {
+		return nil, wrapVaultError(err, "unable to get secrets")
+	}
+	if secrets == nil {
+		return nil, &Error{Kind: ErrSecretNotFound, Err: errors.New("no secrets found")}
+	}
+	if (secrets.Data == nil || len(secrets.Data) == 0) && secrets.Warnings != nil {
+		err := errors.New(strings.Join(secrets.Warnings, ","))
+		return nil, newError(ErrSecretNotFound, err, "no secrets found")
+	}
+	return secrets.Data, nil
+}
+
+func writeSecrets(ctx context.Context, vClient *api.Client, path string, secrets map[string]interface{}) error {
+	_, err := vClient.Logical().WriteWithContext(ctx, path, secrets)
+	return wrapVaultError(err, "unable to make vault request")
+}
+
+func readVersionedSecrets(ctx context.Context, vClient *api.Client, path string) (map[string]interface{}, error) {
+	s, _, err := readVersionedSecretsWithVersion(ctx, vClient, path)
+	return s, err
+}
+
+// readVersionedSecretsWithVersion reads versioned secrets along with the version
+// number Vault reports for them in the secret's metadata.
+func readVersionedSecretsWithVersion(ctx context.Context, vClient *api.Client, path string) (map[string]interface{}, int, error) {
+	secs, err := readSecrets(ctx, vClient, path)
+	if err != nil {
+		return nil, 0, err
 	}
 	// versioned secrets are contained under a 'data' key
 	s, ok := secs["data"].(map[string]interface{})
 	if !ok {
-		return nil, errors.New("no data in versioned secrets")
+		return nil, 0, &Error{Kind: ErrSecretNotFound, Err: errors.New("no data in versioned secrets")}
 	}
-	return s, nil
-}
 
-// PutVersionedSecrets writes versioned secrets to Vault at the configured path.
-// This is comparable to the `vault kv put` command.
-func PutVersionedSecrets(ctx context.Context, cfg Config, secrets map[string]interface{}) error {
-	err := checkDefaults(&cfg)
-	if err != nil {
-		return err
+	var version int
+	if md, ok := secs["metadata"].(map[string]interface{}); ok {
+		if v, ok := md["version"].(json.Number); ok {
+			n, err := v.Int64()
+			if err != nil {
+				return nil, 0, errors.Wrap(err, "unable to parse secret version")
+			}
+			version = int(n)
+		}
 	}
+	return s, version, nil
+}
 
-	vClient, err := login(ctx, cfg)
-	if err != nil {
-		return errors.Wrap(err, "unable to login to vault")
+func writeVersionedSecrets(ctx context.Context, vClient *api.Client, path string, secrets map[string]interface{}, opts ...PutOption) error {
+	var po putOptions
+	for _, opt := range opts {
+		opt(&po)
 	}
 
-	req := vClient.NewRequest(http.MethodPost, "/v1/"+cfg.SecretPath)
-	req.BodyBytes, err = json.Marshal(map[string]map[string]interface{}{
+	body := map[string]interface{}{
 		"data": secrets,
-	})
+	}
+	if po.cas != nil {
+		body["options"] = map[string]interface{}{"cas": *po.cas}
+	}
+
+	req := vClient.NewRequest(http.MethodPost, "/v1/"+path)
+	err := req.SetJSONBody(body)
 	if err != nil {
 		return errors.Wrap(err, "unable to marshal request body")
 	}
-	_, err = vClient.RawRequestWithContext(ctx, req)
-	return errors.Wrap(err, "unable to make vault request")
+	resp, err := vClient.RawRequestWithContext(ctx, req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if po.cas != nil && isCheckAndSetMismatch(err) {
+		return &CheckAndSetError{Path: path, Version: *po.cas, Err: err}
+	}
+	return wrapVaultError(err, "unable to make vault request")
+}
+
+func (cfg *Config) hasTLSConfig() bool {
+	return cfg.TLSCACert != "" || cfg.TLSCACertBytes != "" || cfg.TLSClientCert != "" ||
+		cfg.TLSClientKey != "" || cfg.TLSServerName != "" || cfg.TLSMinVersion != ""
 }
 
 func checkDefaults(cfg *Config) error {
@@ -212,25 +418,46 @@ func checkDefaults(cfg *Config) error {
 		return errors.New("configuration is empty")
 	}
 
-	if cfg.TokenCacheStorageGCS != "" && cfg.TokenCacheStorageRedis != "" {
-		return errors.New("Both Cache types are configured")
+	storages := 0
+	for _, s := range []string{cfg.TokenCacheStorageGCS, cfg.TokenCacheStorageRedis, cfg.TokenCacheStorageFile} {
+		if s != "" {
+			storages++
+		}
 	}
-
-	if cfg.AuthPath == "" {
-		cfg.AuthPath = "auth/gcp"
+	if storages > 1 {
+		return errors.New("Multiple Cache types are configured")
 	}
 
-	if cfg.TokenCacheStorageGCS != "" && cfg.TokenCache == nil {
-		cfg.TokenCache = TokenCacheGCS{cfg: cfg}
+	if cfg.AuthType == "" {
+		cfg.AuthType = AuthTypeGCP
+	}
+	if cfg.AuthNamespace == "" {
+		cfg.AuthNamespace = cfg.Namespace
 	}
 
-	if cfg.TokenCacheStorageRedis != "" && cfg.TokenCache == nil {
-		cfg.TokenCache = TokenCacheRedis{cfg: cfg}
+	if len(cfg.VaultAddresses) > 0 && cfg.vaultAddrs == nil {
+		if cfg.VaultAddress == "" {
+			cfg.VaultAddress = cfg.VaultAddresses[0]
+		}
+		addrs, err := newVaultAddrs(*cfg)
+		if err != nil {
+			return err
+		}
+		cfg.vaultAddrs = addrs
+	}
+	if cfg.AuthPath == "" {
+		switch cfg.AuthType {
+		case AuthTypeKubernetes:
+			cfg.AuthPath = "auth/kubernetes"
+		case AuthTypeAppRole:
+			cfg.AuthPath = "auth/approle"
+		default:
+			cfg.AuthPath = "auth/gcp"
+		}
 	}
 
-	//if expiration is not set, use default
-	if cfg.TokenCacheRefreshThreshold == 0 {
-		cfg.TokenCacheRefreshThreshold = CachedTokenRefreshThresholdDefault
+	if err := checkGCPDefaults(cfg); err != nil {
+		return err
 	}
 
 	//if token cache timeout is not set, use default
@@ -243,11 +470,80 @@ func checkDefaults(cfg *Config) error {
 		cfg.TokenCacheKeyName = TokenCacheKeyNameDefault
 	}
 
+	if cfg.TokenCacheLockTimeout == 0 {
+		cfg.TokenCacheLockTimeout = TokenCacheLockTimeoutDefault
+	}
+
 	//if max retries is not set, use default
 	if cfg.MaxRetries == 0 {
 		cfg.MaxRetries = TokenCacheMaxRetriesDefault
 	}
 
+	//if max concurrency is not set, use default
+	if cfg.MaxConcurrency <= 0 {
+		cfg.MaxConcurrency = MaxConcurrencyDefault
+	}
+
+	checkRefreshDefaults(cfg)
+
+	if cfg.AuthMethod == nil {
+		m, err := newAuthMethod(*cfg)
+		if err != nil {
+			return err
+		}
+		cfg.AuthMethod = m
+	}
+
+	if cfg.TokenCacheKMSKey != "" && cfg.TokenCacheEncryptionKey != "" {
+		return errors.New("Only one of TokenCacheKMSKey and TokenCacheEncryptionKey can be set")
+	}
+	if cfg.TokenCacheKeyWrapper == nil {
+		w, err := newKeyWrapper(cfg)
+		if err != nil {
+			return err
+		}
+		cfg.TokenCacheKeyWrapper = w
+	}
+
+	if cfg.TokenCache == nil {
+		var shared TokenCache
+		switch {
+		case cfg.TokenCacheStorageGCS != "":
+			shared = TokenCacheGCS{cfg: cfg}
+		case cfg.TokenCacheStorageRedis != "":
+			shared = TokenCacheRedis{cfg: cfg}
+		case cfg.TokenCacheStorageFile != "":
+			shared = TokenCacheFile{cfg: cfg}
+		}
+		if shared != nil && cfg.TokenCacheKeyWrapper != nil {
+			shared = encryptTokenCache(shared, cfg.TokenCacheKeyWrapper)
+		}
+
+		// keep tokens in memory as well, so most calls never leave the process
+		key := loginKey(*cfg)
+		switch {
+		case shared != nil && key != "":
+			cfg.TokenCache = NewTokenCacheLayered(cfg, shared)
+		case shared != nil:
+			cfg.TokenCache = shared
+		case key != "":
+			cfg.TokenCache = memoryTokenCache{key: key}
+		}
+	} else if cfg.TokenCacheKeyWrapper != nil {
+		// a TokenCache of the user's own must not store tokens in plain text either
+		cfg.TokenCache = encryptTokenCache(cfg.TokenCache, cfg.TokenCacheKeyWrapper)
+	}
+
+	return nil
+}
+
+// checkRefreshDefaults applies the defaults for when cached tokens are refreshed.
+func checkRefreshDefaults(cfg *Config) {
+	//if expiration is not set, use default
+	if cfg.TokenCacheRefreshThreshold == 0 {
+		cfg.TokenCacheRefreshThreshold = CachedTokenRefreshThresholdDefault
+	}
+
 	if cfg.TokenCacheRefreshRandomOffset == 0 && cfg.TokenCacheRefreshThreshold > 0 {
 		// setting random offset to 1/2 of the refresh threshold
 		seconds := cfg.TokenCacheRefreshThreshold / 2
@@ -257,54 +553,165 @@ func checkDefaults(cfg *Config) error {
 		// TOKEN_CACHE_REFRESH_RANDOM_OFFSET is not set
 		cfg.TokenCacheRefreshRandomOffset = TokenCacheRefreshRandomOffsetDefault
 	}
+}
 
+// checkGCPDefaults applies the defaults for logging in with GCP auth and validates
+// the JWT and impersonation settings.
+func checkGCPDefaults(cfg *Config) error {
+	switch cfg.RoleType {
+	case "":
+		cfg.RoleType = RoleTypeIAM
+	case RoleTypeIAM, RoleTypeGCE:
+	default:
+		return errors.Errorf("unsupported role type %q", cfg.RoleType)
+	}
+
+	//if jwt expiration is not set, use default
+	if cfg.JWTExpiration == 0 {
+		cfg.JWTExpiration = JWTExpirationDefault
+	}
+	if cfg.JWTMaxExpiration == 0 {
+		cfg.JWTMaxExpiration = JWTMaxExpirationDefault
+	}
+	if cfg.JWTExpiration < 0 || cfg.JWTExpiration > cfg.JWTMaxExpiration {
+		return errors.Errorf("JWT expiration of %ds must be between 1 and %ds",
+			cfg.JWTExpiration, cfg.JWTMaxExpiration)
+	}
+	if cfg.JWTAudience == "" {
+		cfg.JWTAudience = "vault/" + cfg.Role
+	}
+	for _, claim := range []string{"aud", "sub", "exp"} {
+		if _, ok := cfg.JWTClaims[claim]; ok {
+			return errors.Errorf("the %q JWT claim cannot be overridden", claim)
+		}
+	}
+
+	if cfg.RoleType == RoleTypeGCE && cfg.ImpersonateServiceAccount != "" {
+		return errors.New("service account impersonation is not supported by the gce role type")
+	}
+	if len(cfg.ImpersonateDelegates) > 0 && cfg.ImpersonateServiceAccount == "" {
+		return errors.New("impersonation delegates require a service account to impersonate")
+	}
 	return nil
 }
 
-func login(ctx context.Context, cfg Config) (*api.Client, error) {
+// login returns a Vault client that is logged in with a token from the cache, if one is
+// configured and readCache is set, or from a fresh login to Vault.
+func login(ctx context.Context, cfg Config, readCache bool) (*api.Client, Token, error) {
 	if cfg.LocalToken != "" {
-		return newLocalClient(ctx, cfg)
+		vClient, err := newLocalClient(ctx, cfg)
+		if err != nil {
+			return nil, Token{}, err
+		}
+		return vClient, Token{Token: cfg.LocalToken}, nil
 	}
 
 	vClient, err := newClient(ctx, cfg)
 	if err != nil {
-		return nil, errors.Wrap(err, "unable to init vault client")
+		return nil, Token{}, errors.Wrap(err, "unable to init vault client")
 	}
 
+	var token Token
+	if key := loginKey(cfg); key != "" {
+		// concurrent logins with the same credentials share a single request
+		key = fmt.Sprintf("%s|%t", key, readCache)
+		ch := logins.DoChan(key, func() (interface{}, error) {
+			// the login is shared, so it must not be cancelled along with the caller
+			// that happened to start it
+			return fetchToken(detachedContext{ctx}, cfg, vClient, readCache)
+		})
+		select {
+		case <-ctx.Done():
+			return nil, Token{}, ctx.Err()
+		case res := <-ch:
+			if res.Err != nil {
+				return nil, Token{}, res.Err
+			}
+			token = res.Val.(Token)
+		}
+	} else {
+		token, err = fetchToken(ctx, cfg, vClient, readCache)
+		if err != nil {
+			return nil, Token{}, err
+		}
+	}
+
+	vClient.SetToken(token.Token)
+	return vClient, token, nil
+}
+
+// fetchToken returns a token from the cache, if one is configured and readCache is
+// set, or from a fresh login to Vault with vClient.
+func fetchToken(ctx context.Context, cfg Config, vClient *api.Client, readCache bool) (Token, error) {
 	timeout := time.Duration(cfg.TokenCacheCtxTimeout)
 	ctx, cancel := context.WithTimeout(ctx, time.Second*timeout)
 	defer cancel()
 
 	b := backoff.NewExponentialBackOff()
 
-	var token Token
-	if cfg.TokenCache != nil {
+	var (
+		token Token
+		err   error
+	)
+	if cfg.TokenCache != nil && readCache {
 		token, err = getVaultTokenFromCache(ctx, cfg, b)
 	}
 	//an error with gcs or redis
 	if err != nil {
-		return nil, err
+		return Token{}, err
+	}
+	if token.Token != "" {
+		return token, nil
 	}
 
-	//token is missing from cache or expired
-	if token.Token == "" {
-		//generate new token from Vault
-		token, err := getToken(ctx, cfg, vClient)
-		if err != nil {
-			return nil, err
+	// processes sharing a file on one host always coordinate their logins
+	if cfg.TokenCache != nil && readCache && (cfg.TokenCacheLock || cfg.TokenCacheStorageFile != "") {
+		// let one instance log in while the others wait to read its token
+		var unlock func()
+		token, unlock = waitForLogin(ctx, cfg, b)
+		if token.Token != "" {
+			return token, nil
 		}
+		defer unlock()
+	}
 
-		vClient.SetToken(token.Auth.ClientToken)
-		//save to cache
-		err = persistVaultTokenToCache(ctx, cfg, token, b)
-		if err != nil {
-			return nil, err
-		}
-		return vClient, nil
+	//token is missing from cache or expired, generate new token from Vault
+	secret, err := getToken(ctx, cfg, vClient)
+	if err != nil {
+		return Token{}, err
 	}
 
-	vClient.SetToken(token.Token)
-	return vClient, nil
+	token, err = tokenFromSecret(secret)
+	if err != nil {
+		return Token{}, err
+	}
+	if cfg.vaultAddrs != nil {
+		token.Address = activeVaultAddress(ctx, cfg)
+	}
+
+	//save to cache
+	err = persistVaultTokenToCache(ctx, cfg, token, b)
+	if err != nil {
+		return Token{}, err
+	}
+	return token, nil
+}
+
+// tokenFromSecret converts the response of a login request into a Token. Tokens
+// without a TTL, such as root tokens, are given a zero expiration.
+func tokenFromSecret(secret *api.Secret) (Token, error) {
+	if secret == nil || secret.Auth == nil {
+		return Token{}, errors.New("no auth information in login response")
+	}
+	ttl, err := secret.TokenTTL()
+	if err != nil {
+		return Token{}, errors.Wrap(err, "unable to retrieve token ttl")
+	}
+	token := Token{Token: secret.Auth.ClientToken}
+	if ttl > 0 {
+		token.Expires = time.Now().Add(ttl)
+	}
+	return token, nil
 }
 
 func getVaultTokenFromCache(ctx context.Context, cfg Config, b *backoff.ExponentialBackOff) (Token, error) {
@@ -318,31 +725,36 @@ func getVaultTokenFromCache(ctx context.Context, cfg Config, b *backoff.Exponent
 	}, backoff.WithMaxRetries(b, uint64(cfg.MaxRetries)))
 
 	if err != nil {
-		return Token{}, errors.Wrapf(err, "unable to retrieve Vault token from cache after %d retries", cfg.MaxRetries)
+		return Token{}, &Error{Kind: ErrTokenCache, Err: errors.Wrapf(err,
+			"unable to retrieve Vault token from cache after %d retries", cfg.MaxRetries)}
 	}
 
-	if !(isExpired(token, cfg) || isRevoked(ctx, cfg, token)) {
+	if token != nil && token.Address != "" && cfg.vaultAddrs != nil &&
+		token.Address != activeVaultAddress(ctx, cfg) {
+		// issued by a cluster we are not talking to
+		return Token{}, nil
+	}
+
+	// tokens we logged in for ourselves do not need to be checked with Vault
+	if !(isExpired(token, cfg) || (!token.trusted && isRevoked(ctx, cfg, token))) {
+		if v, ok := cfg.TokenCache.(verifiedTokenCache); ok && !token.trusted {
+			v.markVerified(ctx, *token)
+		}
 		return *token, nil
 	}
 	//token is expired
 	return Token{}, nil
 }
 
-func persistVaultTokenToCache(ctx context.Context, cfg Config, token *api.Secret, b *backoff.ExponentialBackOff) error {
+func persistVaultTokenToCache(ctx context.Context, cfg Config, token Token, b *backoff.ExponentialBackOff) error {
 	if cfg.TokenCache != nil {
-		tokenExpiration, err := token.TokenTTL()
-		if err != nil {
-			return errors.Wrap(err, "unable to retrieve token ttl")
-		}
-
-		now := time.Now()
-		err = backoff.Retry(func() error {
-			err = cfg.TokenCache.SaveToken(ctx, Token{Token: token.Auth.ClientToken, Expires: now.Add(tokenExpiration)})
-			return err
+		err := backoff.Retry(func() error {
+			return cfg.TokenCache.SaveToken(ctx, token)
 		}, backoff.WithMaxRetries(b, uint64(cfg.MaxRetries)))
 
 		if err != nil {
-			return errors.Wrapf(err, "unable to save Vault token to cache after %d retries", cfg.MaxRetries)
+			return &Error{Kind: ErrTokenCache, Err: errors.Wrapf(err,
+				"unable to save Vault token to cache after %d retries", cfg.MaxRetries)}
 		}
 
 	}
@@ -350,40 +762,61 @@ func persistVaultTokenToCache(ctx context.Context, cfg Config, token *api.Secret
 }
 
 func getToken(ctx context.Context, cfg Config, vClient *api.Client) (*api.Secret, error) {
+	return cfg.AuthMethod.Login(ctx, authClient(vClient, cfg))
+}
 
-	// create signed JWT with our service account
-	jwt, err := newJWT(ctx, cfg)
-	if err != nil {
-		return nil, errors.Wrap(err, "unable to create JWT")
+// authClient returns a copy of vClient that sends requests to the AuthNamespace,
+// for logging in and looking up the token.
+func authClient(vClient *api.Client, cfg Config) *api.Client {
+	if cfg.AuthNamespace == cfg.Namespace {
+		return vClient
 	}
+	return vClient.WithNamespace(cfg.AuthNamespace)
+}
 
-	// 'login' to vault using GCP auth
-	resp, err := vClient.Logical().Write(cfg.AuthPath+"/login", map[string]interface{}{
-		"role": cfg.Role, "jwt": jwt,
-	})
-	if err != nil {
-		return nil, errors.Wrap(err, "unable to make login request")
+// tokenCacheKey is the key tokens are cached under, which includes the namespace
+// they were issued in.
+func tokenCacheKey(cfg *Config) string {
+	if cfg.AuthNamespace == "" {
+		return cfg.TokenCacheKeyName
 	}
-
-	return resp, nil
+	return cfg.TokenCacheKeyName + "/" + strings.Trim(cfg.AuthNamespace, "/")
 }
 
 func newClient(ctx context.Context, cfg Config) (*api.Client, error) {
 	vcfg := api.DefaultConfig()
 	vcfg.MaxRetries = cfg.MaxRetries
 	vcfg.Address = cfg.VaultAddress
-	vcfg.HttpClient = getHTTPClient(ctx, cfg)
-	return api.NewClient(vcfg)
+	hc, err := vaultHTTPClient(ctx, cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to configure vault TLS")
+	}
+	vcfg.HttpClient = hc
+	vClient, err := api.NewClient(vcfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Namespace != "" {
+		vClient.SetNamespace(cfg.Namespace)
+	}
+	return vClient, nil
 }
 
 func newLocalClient(ctx context.Context, cfg Config) (*api.Client, error) {
 	vcfg := api.DefaultConfig()
 	vcfg.Address = cfg.VaultAddress
-	vcfg.HttpClient = getHTTPClient(ctx, cfg)
+	hc, err := vaultHTTPClient(ctx, cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to configure vault TLS")
+	}
+	vcfg.HttpClient = hc
 	vClient, err := api.NewClient(vcfg)
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to init vault client")
 	}
+	if cfg.Namespace != "" {
+		vClient.SetNamespace(cfg.Namespace)
+	}
 
 	vClient.SetToken(cfg.LocalToken)
 
@@ -392,19 +825,31 @@ func newLocalClient(ctx context.Context, cfg Config) (*api.Client, error) {
 
 func newJWT(ctx context.Context, cfg Config) (string, error) {
 	var (
-		jwt string
-		err error
+		jwt      string
+		err      error
+		attempts int
 	)
 
+	sign := newJWTBase
+	switch {
+	case cfg.RoleType == RoleTypeGCE:
+		sign = newGCEJWT
+	case cfg.SignLocally && cfg.ImpersonateServiceAccount == "":
+		sign = newLocalJWT
+	}
+
 	b := backoff.NewExponentialBackOff()
 
+	// errors that retrying will not fix, like IAM permission errors, are permanent
 	err = backoff.Retry(func() error {
-		jwt, err = newJWTBase(ctx, cfg)
+		attempts++
+		jwt, err = sign(ctx, cfg)
 		return err
 	}, backoff.WithMaxRetries(b, uint64(cfg.MaxRetries)))
 
 	if err != nil {
-		return "", errors.Wrapf(err, "unable to sign JWT after %d retries", cfg.MaxRetries)
+		return "", &Error{Kind: ErrJWTSigning, Err: errors.Wrapf(err,
+			"unable to sign JWT after %d retries", attempts-1)}
 	}
 
 	return jwt, nil
@@ -427,26 +872,28 @@ func newJWTBase(ctx context.Context, cfg Config) (string, error) {
 		},
 	}
 
-	claim, err := json.Marshal(map[string]interface{}{
-		"aud": "vault/" + cfg.Role,
-		"sub": serviceAccount,
-		"exp": time.Now().UTC().Add(5 * time.Minute).Unix(),
-	})
-
+	claim, err := newJWTClaim(cfg, serviceAccount)
 	if err != nil {
-		return "", errors.Wrap(err, "unable to encode JWT payload")
+		return "", err
 	}
 
 	gcpURL := "https://iamcredentials.googleapis.com/v1"
 	if cfg.IAMAddress != "" {
 		gcpURL = cfg.IAMAddress
 	}
-	// this additional marshalling is to escape the json
-	payload, err := json.Marshal(string(claim))
+	// the claim is sent as an escaped JSON string
+	req := map[string]interface{}{"payload": string(claim)}
+	if len(cfg.ImpersonateDelegates) > 0 {
+		delegates := make([]string, len(cfg.ImpersonateDelegates))
+		for i, d := range cfg.ImpersonateDelegates {
+			delegates[i] = "projects/-/serviceAccounts/" + d
+		}
+		req["delegates"] = delegates
+	}
+	reqBody, err := json.Marshal(req)
 	if err != nil {
 		return "", errors.Wrap(err, "unable to encode JWT payload")
 	}
-	reqBody := []byte("{\"payload\":" + string(payload) + "}")
 	url := fmt.Sprintf(gcpURL+"/projects/-/serviceAccounts/%s:signJwt", serviceAccount)
 
 	resp, err := hcIAM.Post(url, "application/json", bytes.NewBuffer(reqBody))
@@ -461,13 +908,51 @@ func newJWTBase(ctx context.Context, cfg Config) (string, error) {
 		return "", errors.Wrap(err, "unable to parse response")
 	}
 
-	var data map[string]string
+	if resp.StatusCode != http.StatusOK {
+		iamErr := newIAMError(resp.StatusCode, body)
+		if !iamErr.Temporary() {
+			return "", backoff.Permanent(iamErr)
+		}
+		return "", iamErr
+	}
+
+	var data struct {
+		SignedJwt string `json:"signedJwt"`
+	}
 	err = json.Unmarshal(body, &data)
 	if err != nil {
-		return "", errors.Wrap(err, "unable to sign JWT")
+		return "", backoff.Permanent(errors.Wrap(err, "unable to sign JWT"))
+	}
+	if data.SignedJwt == "" {
+		return "", backoff.Permanent(errors.New("unable to sign JWT: empty response from IAM"))
 	}
 
-	return data["signedJwt"], nil
+	return data.SignedJwt, nil
+}
+
+// newJWTClaim encodes the claim Vault expects for the given service account, along
+// with any additional JWTClaims.
+func newJWTClaim(cfg Config, serviceAccount string) ([]byte, error) {
+	claims := make(map[string]interface{}, len(cfg.JWTClaims)+3)
+	for k, v := range cfg.JWTClaims {
+		claims[k] = v
+	}
+	claims["aud"] = cfg.JWTAudience
+	claims["sub"] = serviceAccount
+	claims["exp"] = time.Now().UTC().Add(time.Duration(cfg.JWTExpiration) * time.Second).Unix()
+
+	claim, err := json.Marshal(claims)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to encode JWT payload")
+	}
+	return claim, nil
+}
+
+// newGCEJWT fetches an instance identity token from the metadata server. The token
+// is already signed by Google so no call to the IAM credentials API is needed.
+// https://www.vaultproject.io/docs/auth/gcp.html#the-gce-authentication-token
+func newGCEJWT(ctx context.Context, cfg Config) (string, error) {
+	return getIdentityToken(ctx, cfg, cfg.JWTAudience)
 }
 
 var findDefaultCredentials = google.FindDefaultCredentials
@@ -475,12 +960,17 @@ var findDefaultCredentials = google.FindDefaultCredentials
 func getServiceAccountInfo(ctx context.Context, cfg Config) (string, oauth2.TokenSource, error) {
 	creds, err := findDefaultCredentials(ctx, CloudScope)
 	if err != nil {
-		return "", nil, errors.Wrap(err, "unable to find credentials to sign JWT")
+		return "", nil, backoff.Permanent(errors.Wrap(err, "unable to find credentials to sign JWT"))
+	}
+
+	// the default credentials only authorize the signing, the JWT is for the target
+	if cfg.ImpersonateServiceAccount != "" {
+		return cfg.ImpersonateServiceAccount, creds.TokenSource, nil
 	}
 
 	serviceAccountEmail, err := getEmailFromCredentials(creds)
 	if err != nil {
-		return "", nil, errors.Wrap(err, "unable to get email from given credentials")
+		return "", nil, backoff.Permanent(errors.Wrap(err, "unable to get email from given credentials"))
 	}
 
 	if serviceAccountEmail == "" {
@@ -516,7 +1006,9 @@ func isExpired(token *Token, cfg Config) bool {
 	//seed random generator
 	rand.Seed(time.Now().UnixNano())
 	//subtract random number of seconds from the expiration to avoid many simultaneous refresh events
-	refreshTime = refreshTime.Add(time.Second * (-1 * time.Duration(rand.Intn(cfg.TokenCacheRefreshRandomOffset))))
+	if cfg.TokenCacheRefreshRandomOffset > 0 {
+		refreshTime = refreshTime.Add(time.Second * (-1 * time.Duration(rand.Intn(cfg.TokenCacheRefreshRandomOffset))))
+	}
 
 	if refreshTime.After(token.Expires) {
 		return true
@@ -530,6 +1022,7 @@ func isRevoked(ctx context.Context, cfg Config, token *Token) bool {
 	if err != nil {
 		return true
 	}
+	vClient = authClient(vClient, cfg)
 	vClient.SetToken(token.Token)
 	_, err = vClient.Auth().Token().LookupSelf()
 	if err != nil {
@@ -109,6 +109,28 @@ func TestGetSecrets(t *testing.T) {
 				"my-other-sec": "abcd",
 			},
 		},
+		{
+			name: "GCE login, success",
+
+			givenEmail: "gce-identity-token",
+			givenCfg: Config{
+				Role:       "my-gcp-role",
+				RoleType:   RoleTypeGCE,
+				SecretPath: "my-secret-path",
+			},
+			givenSecrets: map[string]interface{}{
+				"my-sec":       "123",
+				"my-other-sec": "abcd",
+			},
+
+			wantVaultRead:  true,
+			wantVaultLogin: true,
+			wantMetaHit:    true,
+			wantSecrets: map[string]interface{}{
+				"my-sec":       "123",
+				"my-other-sec": "abcd",
+			},
+		},
 		{
 			name:       "GCP standard login, no meta email, fail",
 			givenCreds: &google.Credentials{},
@@ -424,6 +446,7 @@ func TestGetSecrets(t *testing.T) {
 			cfg.AuthPath = test.givenCfg.AuthPath
 			cfg.SecretPath = test.givenCfg.SecretPath
 			cfg.LocalToken = test.givenCfg.LocalToken
+			cfg.RoleType = test.givenCfg.RoleType
 			cfg.MaxRetries = test.givenCfg.MaxRetries
 			cfg.IAMAddress = iamSvr.URL
 			cfg.MetadataAddress = metaSvr.URL
@@ -656,6 +679,74 @@ func TestPutVersionedSecrets(t *testing.T) {
 	}
 }
 
+func TestImpersonateServiceAccount(t *testing.T) {
+	var (
+		gotPath string
+		gotBody struct {
+			Payload   string   `json:"payload"`
+			Delegates []string `json:"delegates"`
+		}
+		gotMetaHit bool
+	)
+	iamSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&gotBody)
+		json.NewEncoder(w).Encode(iam.SignJwtResponse{
+			SignedJwt: "gcp-signed-jwt-for-vault",
+		})
+	}))
+	defer iamSvr.Close()
+
+	metaSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMetaHit = true
+		io.WriteString(w, "ci@example.com")
+	}))
+	defer metaSvr.Close()
+
+	findDefaultCredentials = func(ctx context.Context, scopes ...string) (*google.Credentials, error) {
+		return &google.Credentials{TokenSource: testTokenSource{}}, nil
+	}
+	defer func() {
+		findDefaultCredentials = google.FindDefaultCredentials
+	}()
+
+	cfg := Config{
+		Role:                      "my-gcp-role",
+		ImpersonateServiceAccount: "svc@example.com",
+		ImpersonateDelegates:      []string{"deployer@example.com"},
+		IAMAddress:                iamSvr.URL,
+		MetadataAddress:           metaSvr.URL,
+	}
+	if err := checkDefaults(&cfg); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	jwt, err := newJWT(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if jwt != "gcp-signed-jwt-for-vault" {
+		t.Errorf("expected signed JWT, got %q", jwt)
+	}
+	if gotMetaHit {
+		t.Error("expected the default service account not to be looked up")
+	}
+	if want := "/projects/-/serviceAccounts/svc@example.com:signJwt"; gotPath != want {
+		t.Errorf("expected signJwt on %q, got %q", want, gotPath)
+	}
+	if want := []string{"projects/-/serviceAccounts/deployer@example.com"}; !cmp.Equal(want, gotBody.Delegates) {
+		t.Errorf("delegates differ: (-want +got)\n%s", cmp.Diff(want, gotBody.Delegates))
+	}
+
+	var claim map[string]interface{}
+	if err := json.Unmarshal([]byte(gotBody.Payload), &claim); err != nil {
+		t.Fatalf("unable to decode payload: %s", err)
+	}
+	if claim["sub"] != "svc@example.com" {
+		t.Errorf("expected sub claim for the impersonated account, got %v", claim["sub"])
+	}
+}
+
 type testTokenSource struct{}
 
 func (t testTokenSource) Token() (*oauth2.Token, error) {
@@ -673,3 +764,17 @@ func (t TokenCacheMock) GetToken(ctx context.Context) (*Token, error) {
 func (t TokenCacheMock) SaveToken(ctx context.Context, token Token) error {
 	return nil
 }
+
+func TestIsExpiredSmallThreshold(t *testing.T) {
+	cfg := Config{TokenCacheRefreshThreshold: 1}
+	if err := checkDefaults(&cfg); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if isExpired(&Token{Expires: time.Now().Add(time.Minute)}, cfg) {
+		t.Error("expected a token expiring in a minute not to be expired")
+	}
+	if !isExpired(&Token{Expires: time.Now()}, cfg) {
+		t.Error("expected a token expiring now to be expired")
+	}
+}
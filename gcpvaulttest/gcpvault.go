@@ -42,6 +42,11 @@ func NewVaultServer(secrets map[string]interface{}) *httptest.Server {
 			var incoming map[string]interface{}
 			json.NewDecoder(r.Body).Decode(&incoming)
 			secrets = incoming
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"errors": []string{"unsupported operation"},
+			})
 		}
 	}))
 }
@@ -0,0 +1,78 @@
+package gcpvaulttest
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+// NewKMSServer creates a test Cloud KMS server that can encrypt and decrypt with
+// any key name. Ciphertext is only valid for the server that created it, and
+// every key reports a single version, 'cryptoKeyVersions/1'.
+func NewKMSServer() *httptest.Server {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		panic(err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Plaintext  []byte `json:"plaintext"`
+			Ciphertext []byte `json:"ciphertext"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		name := strings.TrimPrefix(r.URL.Path, "/")
+
+		switch {
+		case strings.HasSuffix(name, ":encrypt"):
+			name = strings.TrimSuffix(name, ":encrypt")
+			nonce := make([]byte, aead.NonceSize())
+			io.ReadFull(rand.Reader, nonce)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"name":       name + "/cryptoKeyVersions/1",
+				"ciphertext": aead.Seal(nonce, nonce, req.Plaintext, []byte(name)),
+			})
+		case strings.HasSuffix(name, ":decrypt"):
+			name = strings.TrimSuffix(name, ":decrypt")
+			if len(req.Ciphertext) < aead.NonceSize() {
+				writeKMSError(w, "ciphertext is invalid")
+				return
+			}
+			nonce, ciphertext := req.Ciphertext[:aead.NonceSize()], req.Ciphertext[aead.NonceSize():]
+			plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(name))
+			if err != nil {
+				writeKMSError(w, "ciphertext is invalid")
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"plaintext": plaintext,
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func writeKMSError(w http.ResponseWriter, msg string) {
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    http.StatusBadRequest,
+			"message": msg,
+			"status":  "INVALID_ARGUMENT",
+		},
+	})
+}
@@ -0,0 +1,262 @@
+package gcpvaulttest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+type kvVersion struct {
+	data      map[string]interface{}
+	created   time.Time
+	deleted   time.Time
+	destroyed bool
+}
+
+func (v *kvVersion) metadata(version int) map[string]interface{} {
+	deleted := ""
+	if !v.deleted.IsZero() {
+		deleted = v.deleted.Format(time.RFC3339Nano)
+	}
+	return map[string]interface{}{
+		"version":       version,
+		"created_time":  v.created.Format(time.RFC3339Nano),
+		"deletion_time": deleted,
+		"destroyed":     v.destroyed,
+	}
+}
+
+func (v *kvVersion) live() bool {
+	return v.deleted.IsZero() && !v.destroyed
+}
+
+type kvSecret struct {
+	// versions[0] holds version 1
+	versions []*kvVersion
+}
+
+// NewKVServer is a stub Vault server with a KV v2 secrets engine enabled at the
+// given mount. It supports reading, writing and patching versions of secrets,
+// listing and reading metadata, and deleting, undeleting and destroying versions.
+// It can be initialized with secrets keyed by their path within the mount, each of
+// which will be stored as version 1.
+func NewKVServer(mount string, secrets map[string]map[string]interface{}) *httptest.Server {
+	var mu sync.Mutex
+
+	store := map[string]*kvSecret{}
+	for key, data := range secrets {
+		store[key] = &kvSecret{versions: []*kvVersion{{data: data, created: time.Now()}}}
+	}
+
+	prefix := "/v1/" + strings.Trim(mount, "/") + "/"
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/login") {
+			json.NewEncoder(w).Encode(api.Secret{
+				Auth: &api.SecretAuth{ClientToken: "vault-test-token"},
+			})
+			return
+		}
+		if !strings.HasPrefix(r.URL.Path, prefix) {
+			writeErrors(w, http.StatusNotFound, "no handler for route")
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, prefix), "/", 2)
+		if len(parts) != 2 {
+			writeErrors(w, http.StatusNotFound, "no handler for route")
+			return
+		}
+		op, key := parts[0], parts[1]
+		secret := store[key]
+
+		if op == "metadata" && r.Method == http.MethodGet && r.URL.Query().Get("list") == "true" {
+			keys := listKeys(store, key)
+			if len(keys) == 0 {
+				writeErrors(w, http.StatusNotFound)
+				return
+			}
+			writeData(w, http.StatusOK, map[string]interface{}{"keys": keys})
+			return
+		}
+
+		switch {
+		case op == "data" && r.Method == http.MethodGet:
+			if secret == nil {
+				writeErrors(w, http.StatusNotFound)
+				return
+			}
+			version := len(secret.versions)
+			if v := r.URL.Query().Get("version"); v != "" && v != "0" {
+				version, _ = strconv.Atoi(v)
+			}
+			if version < 1 || version > len(secret.versions) {
+				writeErrors(w, http.StatusNotFound)
+				return
+			}
+			kv := secret.versions[version-1]
+			resp := map[string]interface{}{"data": nil, "metadata": kv.metadata(version)}
+			status := http.StatusNotFound
+			if kv.live() {
+				resp["data"] = kv.data
+				status = http.StatusOK
+			}
+			writeData(w, status, resp)
+
+		case op == "data" && (r.Method == http.MethodPost || r.Method == http.MethodPut):
+			var body struct {
+				Data    map[string]interface{} `json:"data"`
+				Options map[string]interface{} `json:"options"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				writeErrors(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			if secret == nil {
+				secret = &kvSecret{}
+				store[key] = secret
+			}
+			if cas, ok := body.Options["cas"]; ok && fmt.Sprint(cas) != strconv.Itoa(len(secret.versions)) {
+				writeErrors(w, http.StatusBadRequest, "check-and-set parameter did not match the current version")
+				return
+			}
+			secret.versions = append(secret.versions, &kvVersion{data: body.Data, created: time.Now()})
+			version := len(secret.versions)
+			writeData(w, http.StatusOK, secret.versions[version-1].metadata(version))
+
+		case op == "data" && r.Method == http.MethodPatch:
+			if r.Header.Get("Content-Type") != "application/merge-patch+json" {
+				writeErrors(w, http.StatusUnsupportedMediaType, "unsupported content type")
+				return
+			}
+			if secret == nil || !secret.versions[len(secret.versions)-1].live() {
+				writeErrors(w, http.StatusNotFound)
+				return
+			}
+			var body struct {
+				Data map[string]interface{} `json:"data"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				writeErrors(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			data := map[string]interface{}{}
+			for k, v := range secret.versions[len(secret.versions)-1].data {
+				data[k] = v
+			}
+			for k, v := range body.Data {
+				if v == nil {
+					delete(data, k)
+					continue
+				}
+				data[k] = v
+			}
+			secret.versions = append(secret.versions, &kvVersion{data: data, created: time.Now()})
+			version := len(secret.versions)
+			writeData(w, http.StatusOK, secret.versions[version-1].metadata(version))
+
+		case op == "data" && r.Method == http.MethodDelete:
+			if secret != nil {
+				secret.versions[len(secret.versions)-1].deleted = time.Now()
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		case op == "metadata" && r.Method == http.MethodGet:
+			if secret == nil {
+				writeErrors(w, http.StatusNotFound)
+				return
+			}
+			versions := map[string]interface{}{}
+			for i, kv := range secret.versions {
+				versions[strconv.Itoa(i+1)] = kv.metadata(i + 1)
+			}
+			current := secret.versions[len(secret.versions)-1]
+			writeData(w, http.StatusOK, map[string]interface{}{
+				"current_version": len(secret.versions),
+				"oldest_version":  1,
+				"max_versions":    0,
+				"created_time":    secret.versions[0].created.Format(time.RFC3339Nano),
+				"updated_time":    current.created.Format(time.RFC3339Nano),
+				"versions":        versions,
+			})
+
+		case op == "delete" || op == "undelete" || op == "destroy":
+			var body struct {
+				Versions []json.Number `json:"versions"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				writeErrors(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			for _, n := range body.Versions {
+				v, err := n.Int64()
+				if err != nil || secret == nil || v < 1 || int(v) > len(secret.versions) {
+					continue
+				}
+				kv := secret.versions[v-1]
+				switch op {
+				case "delete":
+					kv.deleted = time.Now()
+				case "undelete":
+					kv.deleted = time.Time{}
+				case "destroy":
+					kv.destroyed = true
+					kv.data = nil
+				}
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			writeErrors(w, http.StatusMethodNotAllowed, "unsupported operation")
+		}
+	}))
+}
+
+// listKeys returns the keys and folders directly under prefix.
+func listKeys(store map[string]*kvSecret, prefix string) []string {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	seen := map[string]bool{}
+	var keys []string
+	for key := range store {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(key, prefix)
+		if i := strings.Index(rest, "/"); i >= 0 {
+			rest = rest[:i+1]
+		}
+		if !seen[rest] {
+			seen[rest] = true
+			keys = append(keys, rest)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func writeData(w http.ResponseWriter, status int, data map[string]interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+func writeErrors(w http.ResponseWriter, status int, errs ...string) {
+	if errs == nil {
+		errs = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"errors": errs})
+}
@@ -0,0 +1,75 @@
+package gcpvault
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// IAMError is returned when the IAM credentials API refuses to sign the login JWT.
+type IAMError struct {
+	// StatusCode is the HTTP status of the response.
+	StatusCode int
+	// Status is the canonical error code, such as 'PERMISSION_DENIED'.
+	Status string
+	// Reason is the more specific reason given in the error details, such as
+	// 'IAM_PERMISSION_DENIED', if any.
+	Reason  string
+	Message string
+}
+
+func (e *IAMError) Error() string {
+	msg := fmt.Sprintf("iam signJwt failed with status %d", e.StatusCode)
+	if e.Status != "" {
+		msg += " " + e.Status
+	}
+	if e.Reason != "" {
+		msg += " (" + e.Reason + ")"
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// Temporary reports whether the request may succeed if retried.
+func (e *IAMError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests ||
+		e.StatusCode >= http.StatusInternalServerError
+}
+
+// newIAMError parses the Google API error envelope of a failed response. Bodies
+// that are not in the expected format still produce an error with the status code.
+// https://cloud.google.com/apis/design/errors#http_mapping
+func newIAMError(statusCode int, body []byte) *IAMError {
+	var envelope struct {
+		Error struct {
+			Message string `json:"message"`
+			Status  string `json:"status"`
+			Details []struct {
+				Reason string `json:"reason"`
+			} `json:"details"`
+			Errors []struct {
+				Reason string `json:"reason"`
+			} `json:"errors"`
+		} `json:"error"`
+	}
+	iamErr := &IAMError{StatusCode: statusCode}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		iamErr.Message = http.StatusText(statusCode)
+		return iamErr
+	}
+
+	iamErr.Status = envelope.Error.Status
+	iamErr.Message = envelope.Error.Message
+	for _, d := range envelope.Error.Details {
+		if d.Reason != "" {
+			iamErr.Reason = d.Reason
+			break
+		}
+	}
+	if iamErr.Reason == "" && len(envelope.Error.Errors) > 0 {
+		iamErr.Reason = envelope.Error.Errors[0].Reason
+	}
+	return iamErr
+}
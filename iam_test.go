@@ -0,0 +1,199 @@
+package gcpvault
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/oauth2/google"
+)
+
+func TestNewJWTIAMErrors(t *testing.T) {
+	const permissionDenied = `{
+  "error": {
+    "code": 403,
+    "message": "Permission 'iam.serviceAccounts.signJwt' denied on resource (or it may not exist).",
+    "status": "PERMISSION_DENIED",
+    "details": [
+      {
+        "@type": "type.googleapis.com/google.rpc.ErrorInfo",
+        "reason": "IAM_PERMISSION_DENIED",
+        "domain": "iam.googleapis.com"
+      }
+    ]
+  }
+}`
+
+	tests := []struct {
+		name           string
+		givenResponses []int
+		givenBody      string
+
+		wantAttempts int
+		wantIAMErr   *IAMError
+		wantJWT      string
+	}{
+		{
+			name:           "permission denied, not retried",
+			givenResponses: []int{http.StatusForbidden},
+			givenBody:      permissionDenied,
+
+			wantAttempts: 1,
+			wantIAMErr: &IAMError{
+				StatusCode: http.StatusForbidden,
+				Status:     "PERMISSION_DENIED",
+				Reason:     "IAM_PERMISSION_DENIED",
+				Message:    "Permission 'iam.serviceAccounts.signJwt' denied on resource (or it may not exist).",
+			},
+		},
+		{
+			name:           "rate limited, retried",
+			givenResponses: []int{http.StatusTooManyRequests, http.StatusOK},
+			givenBody:      `{"error": {"code": 429, "status": "RESOURCE_EXHAUSTED"}}`,
+
+			wantAttempts: 2,
+			wantJWT:      "gcp-signed-jwt-for-vault",
+		},
+		{
+			name:           "unavailable, retries exhausted",
+			givenResponses: []int{http.StatusBadGateway, http.StatusBadGateway},
+			givenBody:      `<html>bad gateway</html>`,
+
+			wantAttempts: 2,
+			wantIAMErr: &IAMError{
+				StatusCode: http.StatusBadGateway,
+				Message:    "Bad Gateway",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var gotAttempts int
+			iamSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				status := test.givenResponses[gotAttempts]
+				gotAttempts++
+				if status != http.StatusOK {
+					w.WriteHeader(status)
+					io.WriteString(w, test.givenBody)
+					return
+				}
+				io.WriteString(w, `{"keyId": "abc", "signedJwt": "gcp-signed-jwt-for-vault"}`)
+			}))
+			defer iamSvr.Close()
+
+			findDefaultCredentials = func(ctx context.Context, scopes ...string) (*google.Credentials, error) {
+				return &google.Credentials{
+					TokenSource: testTokenSource{},
+					JSON:        []byte(`{"client_email": "jp@example.com"}`),
+				}, nil
+			}
+			defer func() {
+				findDefaultCredentials = google.FindDefaultCredentials
+			}()
+
+			cfg := Config{
+				Role:       "my-gcp-role",
+				MaxRetries: 1,
+				IAMAddress: iamSvr.URL,
+			}
+			if err := checkDefaults(&cfg); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			jwt, err := newJWT(context.Background(), cfg)
+			if gotAttempts != test.wantAttempts {
+				t.Errorf("expected %d attempts, got %d", test.wantAttempts, gotAttempts)
+			}
+			if test.wantIAMErr == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				if jwt != test.wantJWT {
+					t.Errorf("expected JWT %q, got %q", test.wantJWT, jwt)
+				}
+				return
+			}
+
+			var iamErr *IAMError
+			if !errors.As(err, &iamErr) {
+				t.Fatalf("expected an IAMError, got %v", err)
+			}
+			if !cmp.Equal(test.wantIAMErr, iamErr) {
+				t.Errorf("errors differ: (-want +got)\n%s", cmp.Diff(test.wantIAMErr, iamErr))
+			}
+		})
+	}
+}
+
+func TestNewJWTCredentialErrors(t *testing.T) {
+	tests := []struct {
+		name            string
+		givenCredsErr   bool
+		givenMetaStatus int
+
+		wantAttempts int
+	}{
+		{
+			name:          "no default credentials, not retried",
+			givenCredsErr: true,
+
+			wantAttempts: 1,
+		},
+		{
+			name:            "metadata not found, not retried",
+			givenMetaStatus: http.StatusNotFound,
+
+			wantAttempts: 1,
+		},
+		{
+			name:            "metadata unavailable, retried",
+			givenMetaStatus: http.StatusServiceUnavailable,
+
+			wantAttempts: 3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var gotAttempts int
+			metaSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotAttempts++
+				w.WriteHeader(test.givenMetaStatus)
+			}))
+			defer metaSvr.Close()
+
+			findDefaultCredentials = func(ctx context.Context, scopes ...string) (*google.Credentials, error) {
+				if test.givenCredsErr {
+					gotAttempts++
+					return nil, errors.New("could not find default credentials")
+				}
+				return &google.Credentials{TokenSource: testTokenSource{}}, nil
+			}
+			defer func() {
+				findDefaultCredentials = google.FindDefaultCredentials
+			}()
+
+			cfg := Config{
+				Role:            "my-gcp-role",
+				MaxRetries:      2,
+				MetadataAddress: metaSvr.URL,
+			}
+			if err := checkDefaults(&cfg); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			_, err := newJWT(context.Background(), cfg)
+			if !errors.Is(err, ErrJWTSigning) {
+				t.Errorf("expected ErrJWTSigning, got %v", err)
+			}
+			if gotAttempts != test.wantAttempts {
+				t.Errorf("expected %d attempts, got %d", test.wantAttempts, gotAttempts)
+			}
+		})
+	}
+}
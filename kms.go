@@ -0,0 +1,98 @@
+package gcpvault
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+// NewKMSKeyWrapper returns a KeyWrapper that wraps keys with the Cloud KMS key
+// named by cfg.TokenCacheKMSKey, using the default credentials. The default
+// credentials need roles/cloudkms.cryptoKeyEncrypterDecrypter on the key.
+func NewKMSKeyWrapper(cfg Config) KeyWrapper {
+	return kmsKeyWrapper{cfg: &cfg}
+}
+
+type kmsKeyWrapper struct {
+	cfg *Config
+}
+
+func (w kmsKeyWrapper) WrapKey(ctx context.Context, key []byte) ([]byte, string, error) {
+	var resp struct {
+		Name       string `json:"name"`
+		Ciphertext []byte `json:"ciphertext"`
+	}
+	err := w.call(ctx, "encrypt", map[string][]byte{"plaintext": key}, &resp)
+	if err != nil {
+		return nil, "", err
+	}
+	// the name is the key version used, which decryption does not need but
+	// records which version tokens were cached with
+	return resp.Ciphertext, resp.Name, nil
+}
+
+func (w kmsKeyWrapper) UnwrapKey(ctx context.Context, wrapped []byte, keyID string) ([]byte, error) {
+	// only decrypt with versions of our own key
+	if !strings.HasPrefix(keyID, w.cfg.TokenCacheKMSKey+"/cryptoKeyVersions/") {
+		return nil, errUnknownKey
+	}
+	var resp struct {
+		Plaintext []byte `json:"plaintext"`
+	}
+	err := w.call(ctx, "decrypt", map[string][]byte{"ciphertext": wrapped}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Plaintext, nil
+}
+
+func (w kmsKeyWrapper) call(ctx context.Context, method string, req, resp interface{}) error {
+	creds, err := findDefaultCredentials(ctx, CloudScope)
+	if err != nil {
+		return errors.Wrap(err, "unable to find credentials for Cloud KMS")
+	}
+	hc := getHTTPClient(ctx, *w.cfg)
+	hcKMS := &http.Client{
+		Timeout: hc.Timeout,
+		Transport: &oauth2.Transport{
+			Source: creds.TokenSource,
+			Base:   hc.Transport,
+		},
+	}
+
+	kmsURL := "https://cloudkms.googleapis.com/v1"
+	if w.cfg.KMSAddress != "" {
+		kmsURL = w.cfg.KMSAddress
+	}
+	reqBody, err := json.Marshal(req)
+	if err != nil {
+		return errors.Wrap(err, "unable to encode KMS request")
+	}
+	r, err := http.NewRequest(http.MethodPost, kmsURL+"/"+w.cfg.TokenCacheKMSKey+":"+method,
+		bytes.NewReader(reqBody))
+	if err != nil {
+		return errors.Wrap(err, "unable to create KMS request")
+	}
+	r.Header.Set("Content-Type", "application/json")
+
+	res, err := hcKMS.Do(r.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(err, "unable to POST")
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return errors.Wrap(err, "unable to read KMS response")
+	}
+	if res.StatusCode != http.StatusOK {
+		return errors.Errorf("unable to %s with Cloud KMS: %s: %s", method, res.Status, body)
+	}
+	return errors.Wrap(json.Unmarshal(body, resp), "unable to parse KMS response")
+}
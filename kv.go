@@ -0,0 +1,141 @@
+package gcpvault
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// GetKVSecret reads a version of the KV v2 secret at the configured SecretPath
+// within the configured KVMount. A version of 0 reads the latest version.
+// This is comparable to the `vault kv get -version` command.
+func GetKVSecret(ctx context.Context, cfg Config, version int) (*api.KVSecret, error) {
+	c, err := newKVClient(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return c.GetKVSecret(ctx, c.cfg.KVMount, c.cfg.SecretPath, version)
+}
+
+// GetKVMetadata reads the metadata of the KV v2 secret at the configured SecretPath
+// within the configured KVMount.
+// This is comparable to the `vault kv metadata get` command.
+func GetKVMetadata(ctx context.Context, cfg Config) (*api.KVMetadata, error) {
+	c, err := newKVClient(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return c.GetKVMetadata(ctx, c.cfg.KVMount, c.cfg.SecretPath)
+}
+
+// DeleteKVSecret soft deletes versions of the KV v2 secret at the configured
+// SecretPath within the configured KVMount. The latest version is deleted when no
+// versions are given.
+// This is comparable to the `vault kv delete` command.
+func DeleteKVSecret(ctx context.Context, cfg Config, versions ...int) error {
+	c, err := newKVClient(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	return c.DeleteKVSecret(ctx, c.cfg.KVMount, c.cfg.SecretPath, versions...)
+}
+
+// UndeleteKVSecret restores soft deleted versions of the KV v2 secret at the
+// configured SecretPath within the configured KVMount.
+// This is comparable to the `vault kv undelete` command.
+func UndeleteKVSecret(ctx context.Context, cfg Config, versions ...int) error {
+	c, err := newKVClient(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	return c.UndeleteKVSecret(ctx, c.cfg.KVMount, c.cfg.SecretPath, versions...)
+}
+
+// DestroyKVSecret permanently removes versions of the KV v2 secret at the configured
+// SecretPath within the configured KVMount.
+// This is comparable to the `vault kv destroy` command.
+func DestroyKVSecret(ctx context.Context, cfg Config, versions ...int) error {
+	c, err := newKVClient(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	return c.DestroyKVSecret(ctx, c.cfg.KVMount, c.cfg.SecretPath, versions...)
+}
+
+func newKVClient(ctx context.Context, cfg Config) (*Client, error) {
+	if cfg.KVMount == "" {
+		return nil, errors.New("KV mount is not configured")
+	}
+	return newOneShotClient(ctx, cfg)
+}
+
+// GetKVSecret reads a version of the KV v2 secret stored under key in the given
+// mount. A version of 0 reads the latest version. The data of deleted or destroyed
+// versions is nil, with the details available in the secret's VersionMetadata.
+func (c *Client) GetKVSecret(ctx context.Context, mount, key string, version int) (*api.KVSecret, error) {
+	var secret *api.KVSecret
+	err := c.withVault(ctx, func(vClient *api.Client) (err error) {
+		if version == 0 {
+			secret, err = vClient.KVv2(mount).Get(ctx, key)
+		} else {
+			secret, err = vClient.KVv2(mount).GetVersion(ctx, key, version)
+		}
+		return wrapVaultError(err, "unable to get secrets")
+	})
+	if err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// GetKVMetadata reads the metadata of the KV v2 secret stored under key in the
+// given mount, including the created and deletion times of every version.
+func (c *Client) GetKVMetadata(ctx context.Context, mount, key string) (*api.KVMetadata, error) {
+	var md *api.KVMetadata
+	err := c.withVault(ctx, func(vClient *api.Client) (err error) {
+		md, err = vClient.KVv2(mount).GetMetadata(ctx, key)
+		return wrapVaultError(err, "unable to get secret metadata")
+	})
+	if err != nil {
+		return nil, err
+	}
+	return md, nil
+}
+
+// DeleteKVSecret soft deletes versions of the KV v2 secret stored under key in the
+// given mount. The latest version is deleted when no versions are given.
+func (c *Client) DeleteKVSecret(ctx context.Context, mount, key string, versions ...int) error {
+	return c.withVault(ctx, func(vClient *api.Client) (err error) {
+		if len(versions) == 0 {
+			err = vClient.KVv2(mount).Delete(ctx, key)
+		} else {
+			err = vClient.KVv2(mount).DeleteVersions(ctx, key, versions)
+		}
+		return wrapVaultError(err, "unable to delete secret")
+	})
+}
+
+// UndeleteKVSecret restores soft deleted versions of the KV v2 secret stored under
+// key in the given mount.
+func (c *Client) UndeleteKVSecret(ctx context.Context, mount, key string, versions ...int) error {
+	if len(versions) == 0 {
+		return errors.New("no versions given to undelete")
+	}
+	return c.withVault(ctx, func(vClient *api.Client) error {
+		err := vClient.KVv2(mount).Undelete(ctx, key, versions)
+		return wrapVaultError(err, "unable to undelete secret")
+	})
+}
+
+// DestroyKVSecret permanently removes versions of the KV v2 secret stored under key
+// in the given mount.
+func (c *Client) DestroyKVSecret(ctx context.Context, mount, key string, versions ...int) error {
+	if len(versions) == 0 {
+		return errors.New("no versions given to destroy")
+	}
+	return c.withVault(ctx, func(vClient *api.Client) error {
+		err := vClient.KVv2(mount).Destroy(ctx, key, versions)
+		return wrapVaultError(err, "unable to destroy secret")
+	})
+}
@@ -0,0 +1,138 @@
+package gcpvault
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/NYTimes/gcp-vault/gcpvaulttest"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestKVSecretVersions(t *testing.T) {
+	vaultSvr := gcpvaulttest.NewKVServer("secret", map[string]map[string]interface{}{
+		"my-app/creds": {"my-sec": "123"},
+	})
+	defer vaultSvr.Close()
+
+	ctx := context.Background()
+	cfg := Config{
+		VaultAddress: vaultSvr.URL,
+		LocalToken:   "my-local-token",
+		KVMount:      "secret",
+		SecretPath:   "my-app/creds",
+	}
+
+	// versioned writes still go through the raw data path
+	err := PutVersionedSecrets(ctx, Config{
+		VaultAddress: vaultSvr.URL,
+		LocalToken:   "my-local-token",
+		SecretPath:   "secret/data/my-app/creds",
+	}, map[string]interface{}{"my-sec": "456"})
+	if err != nil {
+		t.Fatalf("unable to put secrets: %s", err)
+	}
+
+	latest, err := GetKVSecret(ctx, cfg, 0)
+	if err != nil {
+		t.Fatalf("unable to get latest version: %s", err)
+	}
+	if want := map[string]interface{}{"my-sec": "456"}; !cmp.Equal(want, latest.Data) {
+		t.Errorf("latest secrets differ: (-want +got)\n%s", cmp.Diff(want, latest.Data))
+	}
+	if latest.VersionMetadata.Version != 2 {
+		t.Errorf("expected latest version 2, got %d", latest.VersionMetadata.Version)
+	}
+
+	first, err := GetKVSecret(ctx, cfg, 1)
+	if err != nil {
+		t.Fatalf("unable to get version 1: %s", err)
+	}
+	if want := map[string]interface{}{"my-sec": "123"}; !cmp.Equal(want, first.Data) {
+		t.Errorf("version 1 secrets differ: (-want +got)\n%s", cmp.Diff(want, first.Data))
+	}
+
+	err = DeleteKVSecret(ctx, cfg, 1)
+	if err != nil {
+		t.Fatalf("unable to delete version 1: %s", err)
+	}
+	md, err := GetKVMetadata(ctx, cfg)
+	if err != nil {
+		t.Fatalf("unable to get metadata: %s", err)
+	}
+	if md.CurrentVersion != 2 {
+		t.Errorf("expected current version 2, got %d", md.CurrentVersion)
+	}
+	if md.Versions["1"].DeletionTime.IsZero() {
+		t.Error("expected version 1 to be deleted")
+	}
+
+	err = UndeleteKVSecret(ctx, cfg, 1)
+	if err != nil {
+		t.Fatalf("unable to undelete version 1: %s", err)
+	}
+	first, err = GetKVSecret(ctx, cfg, 1)
+	if err != nil {
+		t.Fatalf("unable to get version 1: %s", err)
+	}
+	if first.Data == nil {
+		t.Error("expected version 1 to be restored")
+	}
+
+	err = DestroyKVSecret(ctx, cfg, 1)
+	if err != nil {
+		t.Fatalf("unable to destroy version 1: %s", err)
+	}
+	md, err = GetKVMetadata(ctx, cfg)
+	if err != nil {
+		t.Fatalf("unable to get metadata: %s", err)
+	}
+	if !md.Versions["1"].Destroyed {
+		t.Error("expected version 1 to be destroyed")
+	}
+}
+
+func TestPutVersionedSecretsCheckAndSet(t *testing.T) {
+	vaultSvr := gcpvaulttest.NewKVServer("secret", map[string]map[string]interface{}{
+		"my-app/creds": {"my-sec": "123"},
+	})
+	defer vaultSvr.Close()
+
+	ctx := context.Background()
+	cfg := Config{
+		VaultAddress: vaultSvr.URL,
+		LocalToken:   "my-local-token",
+		SecretPath:   "secret/data/my-app/creds",
+	}
+
+	err := PutVersionedSecrets(ctx, cfg, map[string]interface{}{"my-sec": "456"}, WithCheckAndSet(1))
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+
+	err = PutVersionedSecrets(ctx, cfg, map[string]interface{}{"my-sec": "789"}, WithCheckAndSet(1))
+	var casErr *CheckAndSetError
+	if !errors.As(err, &casErr) {
+		t.Fatalf("expected a CheckAndSetError, got %v", err)
+	}
+	if casErr.Version != 1 {
+		t.Errorf("expected conflict on version 1, got %d", casErr.Version)
+	}
+
+	err = UpdateVersionedSecrets(ctx, cfg, func(secrets map[string]interface{}) (map[string]interface{}, error) {
+		secrets["my-other-sec"] = "abcd"
+		return secrets, nil
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+
+	got, err := GetVersionedSecrets(ctx, cfg)
+	if err != nil {
+		t.Fatalf("unable to get secrets: %s", err)
+	}
+	want := map[string]interface{}{"my-sec": "456", "my-other-sec": "abcd"}
+	if !cmp.Equal(want, got) {
+		t.Errorf("secrets differ: (-want +got)\n%s", cmp.Diff(want, got))
+	}
+}
@@ -0,0 +1,188 @@
+package gcpvault
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// Lease is a dynamic secret, such as database credentials, read from Vault along
+// with the lease that controls how long it remains valid. The lease is renewed in
+// the background until it reaches its max TTL, Vault refuses to renew it or Close is
+// called.
+type Lease struct {
+	Secrets       map[string]interface{}
+	LeaseID       string
+	LeaseDuration time.Duration
+	Renewable     bool
+
+	client     *Client
+	ownsClient bool
+
+	stop      chan struct{}
+	stopped   chan struct{}
+	done      chan struct{}
+	err       error
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// ErrLeaseExpired is reported by Lease.Err when a lease reaches the end of its TTL
+// without any renewal errors.
+var ErrLeaseExpired = errors.New("lease can no longer be renewed")
+
+// GetLeasedSecrets reads the dynamic secret at the configured SecretPath and keeps
+// its lease renewed. See Client.GetLeasedSecrets for details. Closing the returned
+// Lease also closes the Client created to read it.
+func GetLeasedSecrets(ctx context.Context, cfg Config) (*Lease, error) {
+	c, err := NewClient(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	l, err := c.GetLeasedSecrets(ctx, c.cfg.SecretPath)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	l.ownsClient = true
+	return l, nil
+}
+
+// GetLeasedSecrets reads the dynamic secret at the given path and starts renewing
+// its lease in the background. Once the lease can no longer be renewed, the Lease's
+// Done channel is closed so callers know to read a fresh secret. The lease is
+// revoked when the Lease or the Client is closed.
+func (c *Client) GetLeasedSecrets(ctx context.Context, path string) (*Lease, error) {
+	var (
+		vClient *api.Client
+		secret  *api.Secret
+	)
+	err := c.withVault(ctx, func(v *api.Client) (err error) {
+		vClient = v
+		secret, err = v.Logical().ReadWithContext(ctx, path)
+		return wrapVaultError(err, "unable to get secrets")
+	})
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		return nil, &Error{Kind: ErrSecretNotFound, Err: errors.New("no secrets found")}
+	}
+
+	l := &Lease{
+		Secrets:       secret.Data,
+		LeaseID:       secret.LeaseID,
+		LeaseDuration: time.Second * time.Duration(secret.LeaseDuration),
+		Renewable:     secret.Renewable,
+		client:        c,
+		stop:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	if l.LeaseID == "" {
+		// nothing to renew or revoke
+		l.err = ErrLeaseExpired
+		close(l.done)
+		close(l.stopped)
+		return l, nil
+	}
+
+	behavior := api.RenewBehaviorErrorOnErrors
+	if !secret.Renewable {
+		// wait out the lease instead
+		behavior = api.RenewBehaviorRenewDisabled
+	}
+	watcher, err := vClient.NewLifetimeWatcher(&api.LifetimeWatcherInput{
+		Secret:        secret,
+		RenewBehavior: behavior,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create lifetime watcher")
+	}
+
+	c.leaseMu.Lock()
+	if c.leases == nil {
+		c.leases = map[*Lease]struct{}{}
+	}
+	c.leases[l] = struct{}{}
+	c.leaseMu.Unlock()
+
+	go watcher.Start()
+	go l.watch(watcher)
+	return l, nil
+}
+
+func (l *Lease) watch(watcher *api.LifetimeWatcher) {
+	defer close(l.stopped)
+	defer watcher.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case err := <-watcher.DoneCh():
+			if err == nil {
+				err = ErrLeaseExpired
+			}
+			l.err = err
+			close(l.done)
+			return
+		case <-watcher.RenewCh():
+		}
+	}
+}
+
+// Done returns a channel that is closed once the lease can no longer be renewed.
+func (l *Lease) Done() <-chan struct{} {
+	return l.done
+}
+
+// Err returns why the lease can no longer be renewed. It returns nil until Done is
+// closed, and ErrLeaseExpired if the lease simply reached the end of its TTL.
+func (l *Lease) Err() error {
+	select {
+	case <-l.done:
+		return l.err
+	default:
+		return nil
+	}
+}
+
+// Close stops renewing the lease and revokes it.
+func (l *Lease) Close() error {
+	err := l.revoke()
+	if l.ownsClient {
+		l.client.Close()
+	}
+	return err
+}
+
+func (l *Lease) revoke() error {
+	l.closeOnce.Do(func() {
+		if l.LeaseID == "" {
+			return
+		}
+		close(l.stop)
+		<-l.stopped
+
+		l.client.leaseMu.Lock()
+		delete(l.client.leases, l)
+		l.client.leaseMu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(),
+			time.Second*time.Duration(l.client.cfg.TokenCacheCtxTimeout))
+		defer cancel()
+		// the Client may have logged in again since the lease was read
+		vClient, err := l.client.vault(ctx)
+		if err == nil {
+			err = vClient.Sys().RevokeWithContext(ctx, l.LeaseID)
+		}
+		if err != nil {
+			l.closeErr = wrapVaultError(err, "unable to revoke lease")
+		}
+	})
+	return l.closeErr
+}
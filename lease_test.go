@@ -0,0 +1,137 @@
+package gcpvault
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/hashicorp/vault/api"
+)
+
+func TestGetLeasedSecrets(t *testing.T) {
+	var gotRenewals, gotRevokes int32
+	vaultSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/database/creds/my-role":
+			json.NewEncoder(w).Encode(api.Secret{
+				LeaseID:       "database/creds/my-role/abcd",
+				LeaseDuration: 3600,
+				Renewable:     true,
+				Data:          map[string]interface{}{"username": "jp", "password": "hunter2"},
+			})
+		case "/v1/sys/leases/renew":
+			// the lease hits its max TTL
+			atomic.AddInt32(&gotRenewals, 1)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"errors":["lease not found"]}`)
+		case "/v1/sys/leases/revoke":
+			atomic.AddInt32(&gotRevokes, 1)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer vaultSvr.Close()
+
+	l, err := GetLeasedSecrets(context.Background(), Config{
+		VaultAddress: vaultSvr.URL,
+		LocalToken:   "my-local-token",
+		SecretPath:   "database/creds/my-role",
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+
+	want := map[string]interface{}{"username": "jp", "password": "hunter2"}
+	if !cmp.Equal(want, l.Secrets) {
+		t.Errorf("secrets differ: (-want +got)\n%s", cmp.Diff(want, l.Secrets))
+	}
+	if l.LeaseID != "database/creds/my-role/abcd" || l.LeaseDuration != time.Hour || !l.Renewable {
+		t.Errorf("unexpected lease %q for %s, renewable %t", l.LeaseID, l.LeaseDuration, l.Renewable)
+	}
+
+	select {
+	case <-l.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for lease renewal to fail")
+	}
+	if l.Err() == nil {
+		t.Error("expected a renewal error")
+	}
+	if got := atomic.LoadInt32(&gotRenewals); got != 1 {
+		t.Errorf("expected 1 renewal attempt, got %d", got)
+	}
+
+	err = l.Close()
+	if err != nil {
+		t.Errorf("expected no error closing lease, got %s", err)
+	}
+	if got := atomic.LoadInt32(&gotRevokes); got != 1 {
+		t.Errorf("expected 1 revoke, got %d", got)
+	}
+}
+
+func TestGetLeasedSecretsRenewed(t *testing.T) {
+	var gotRenewals, gotRevokes int32
+	vaultSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/database/creds/my-role":
+			json.NewEncoder(w).Encode(api.Secret{
+				LeaseID:       "database/creds/my-role/abcd",
+				LeaseDuration: 3600,
+				Renewable:     true,
+				Data:          map[string]interface{}{"username": "jp", "password": "hunter2"},
+			})
+		case "/v1/sys/leases/renew":
+			atomic.AddInt32(&gotRenewals, 1)
+			json.NewEncoder(w).Encode(api.Secret{
+				LeaseID:       "database/creds/my-role/abcd",
+				LeaseDuration: 3600,
+				Renewable:     true,
+			})
+		case "/v1/sys/leases/revoke":
+			atomic.AddInt32(&gotRevokes, 1)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer vaultSvr.Close()
+
+	l, err := GetLeasedSecrets(context.Background(), Config{
+		VaultAddress: vaultSvr.URL,
+		LocalToken:   "my-local-token",
+		SecretPath:   "database/creds/my-role",
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&gotRenewals) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the lease to be renewed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the renewed lease is still good
+	select {
+	case <-l.Done():
+		t.Fatalf("expected the lease to stay open, got %v", l.Err())
+	case <-time.After(100 * time.Millisecond):
+	}
+	if l.Err() != nil {
+		t.Errorf("expected no error, got %s", l.Err())
+	}
+
+	err = l.Close()
+	if err != nil {
+		t.Errorf("expected no error closing lease, got %s", err)
+	}
+	if got := atomic.LoadInt32(&gotRevokes); got != 1 {
+		t.Errorf("expected 1 revoke, got %d", got)
+	}
+}
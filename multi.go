@@ -0,0 +1,58 @@
+package gcpvault
+
+import (
+	"context"
+	"sync"
+)
+
+// SecretResult holds the outcome of reading a single path with GetSecretsMulti.
+type SecretResult struct {
+	Secrets map[string]interface{}
+	Err     error
+}
+
+// GetSecretsMulti logs in to Vault once and reads the secrets at each of the given
+// paths, ignoring the configured SecretPath. See Client.GetSecretsMulti for details.
+// The returned error is only set if the login fails.
+func GetSecretsMulti(ctx context.Context, cfg Config, paths ...string) (map[string]SecretResult, error) {
+	c, err := newOneShotClient(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return c.GetSecretsMulti(ctx, paths...), nil
+}
+
+// GetSecretsMulti reads the secrets at each of the given paths in parallel, with at
+// most MaxConcurrency reads in flight at once. Every path has an entry in the
+// returned map, holding either its secrets or the error encountered reading it, so
+// one failing path does not hide the others.
+func (c *Client) GetSecretsMulti(ctx context.Context, paths ...string) map[string]SecretResult {
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		sem     = make(chan struct{}, c.cfg.MaxConcurrency)
+		results = make(map[string]SecretResult, len(paths))
+	)
+	for _, path := range paths {
+		path := path
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var res SecretResult
+			select {
+			case sem <- struct{}{}:
+				res.Secrets, res.Err = c.GetSecrets(ctx, path)
+				<-sem
+			case <-ctx.Done():
+				res.Err = ctx.Err()
+			}
+
+			mu.Lock()
+			results[path] = res
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,42 @@
+package gcpvault
+
+import (
+	"context"
+	"testing"
+
+	"github.com/NYTimes/gcp-vault/gcpvaulttest"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestGetSecretsMulti(t *testing.T) {
+	vaultSvr := gcpvaulttest.NewKVServer("secret", map[string]map[string]interface{}{
+		"db":      {"password": "hunter2"},
+		"api-key": {"key": "abcd"},
+	})
+	defer vaultSvr.Close()
+
+	results, err := GetSecretsMulti(context.Background(), Config{
+		VaultAddress: vaultSvr.URL,
+		LocalToken:   "my-local-token",
+	}, "secret/data/db", "secret/data/api-key", "secret/data/tls")
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+	for _, path := range []string{"secret/data/db", "secret/data/api-key"} {
+		if results[path].Err != nil {
+			t.Errorf("expected no error for %s, got %s", path, results[path].Err)
+		}
+	}
+	if results["secret/data/tls"].Err == nil {
+		t.Error("expected an error for the missing path")
+	}
+
+	want := map[string]interface{}{"password": "hunter2"}
+	if got := results["secret/data/db"].Secrets["data"]; !cmp.Equal(want, got) {
+		t.Errorf("secrets differ: (-want +got)\n%s", cmp.Diff(want, got))
+	}
+}
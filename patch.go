@@ -0,0 +1,91 @@
+package gcpvault
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// PatchSecrets merges the given changes into the secrets at the configured path
+// without replacing the keys it leaves out. See Client.PatchSecrets for details.
+func PatchSecrets(ctx context.Context, cfg Config, patch map[string]interface{}) error {
+	c, err := newOneShotClient(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	return c.PatchSecrets(ctx, c.cfg.SecretPath, patch)
+}
+
+// PatchSecrets merges the given changes into the secrets at the given path following
+// JSON merge patch rules: keys in patch overwrite existing keys, nested maps are
+// merged and keys set to nil are removed.
+// This is comparable to the `vault kv patch` command.
+//
+// On KV v2 paths this uses Vault's PATCH support. Servers that predate it, and KV v1
+// paths, fall back to reading the secrets, merging them and writing them back. For
+// KV v2 the write is made with a check-and-set on the version that was read and the
+// cycle is retried if another writer got there first. KV v1 offers no such
+// protection, so concurrent writes to the same KV v1 path may still be lost.
+func (c *Client) PatchSecrets(ctx context.Context, path string, patch map[string]interface{}) error {
+	var versioned bool
+	err := c.withVault(ctx, func(vClient *api.Client) error {
+		_, err := vClient.Logical().JSONMergePatch(ctx, path, map[string]interface{}{
+			"data": patch,
+		})
+		if err == nil {
+			return nil
+		}
+		var re *api.ResponseError
+		if !errors.As(err, &re) || re.StatusCode != http.StatusMethodNotAllowed {
+			return wrapVaultError(err, "unable to make vault request")
+		}
+
+		// PATCH is not supported here, merge the secrets ourselves
+		secrets, err := readSecrets(ctx, vClient, path)
+		if err != nil {
+			return err
+		}
+		if !isVersioned(secrets) {
+			return writeSecrets(ctx, vClient, path, mergePatch(secrets, patch))
+		}
+		versioned = true
+		return nil
+	})
+	if err != nil || !versioned {
+		return err
+	}
+	return c.UpdateVersionedSecrets(ctx, path, func(current map[string]interface{}) (map[string]interface{}, error) {
+		return mergePatch(current, patch), nil
+	})
+}
+
+// isVersioned reports whether secrets read from a path look like a KV v2 response.
+// Only the metadata is checked, as the data of a deleted version is null.
+func isVersioned(secrets map[string]interface{}) bool {
+	_, md := secrets["metadata"].(map[string]interface{})
+	return md
+}
+
+// mergePatch applies patch to target as described by RFC 7386.
+func mergePatch(target, patch map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(target)+len(patch))
+	for k, v := range target {
+		out[k] = v
+	}
+	for k, v := range patch {
+		if v == nil {
+			delete(out, k)
+			continue
+		}
+		pm, ok := v.(map[string]interface{})
+		if !ok {
+			out[k] = v
+			continue
+		}
+		tm, _ := out[k].(map[string]interface{})
+		out[k] = mergePatch(tm, pm)
+	}
+	return out
+}
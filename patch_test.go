@@ -0,0 +1,125 @@
+package gcpvault
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/NYTimes/gcp-vault/gcpvaulttest"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPatchSecrets(t *testing.T) {
+	tests := []struct {
+		name         string
+		givenKV      bool
+		givenPath    string
+		givenSecrets map[string]interface{}
+		givenPatch   map[string]interface{}
+
+		wantSecrets map[string]interface{}
+	}{
+		{
+			name:         "KV v2 patch",
+			givenKV:      true,
+			givenPath:    "secret/data/my-app/creds",
+			givenSecrets: map[string]interface{}{"my-sec": "123", "my-old-sec": "abcd"},
+			givenPatch:   map[string]interface{}{"my-other-sec": "wxyz", "my-old-sec": nil},
+
+			wantSecrets: map[string]interface{}{"my-sec": "123", "my-other-sec": "wxyz"},
+		},
+		{
+			name:         "KV v1 read-merge-write",
+			givenPath:    "my-secret-path",
+			givenSecrets: map[string]interface{}{"my-sec": "123", "my-old-sec": "abcd"},
+			givenPatch:   map[string]interface{}{"my-other-sec": "wxyz", "my-old-sec": nil},
+
+			wantSecrets: map[string]interface{}{"my-sec": "123", "my-other-sec": "wxyz"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vaultSvr := gcpvaulttest.NewVaultServer(test.givenSecrets)
+			if test.givenKV {
+				vaultSvr = gcpvaulttest.NewKVServer("secret", map[string]map[string]interface{}{
+					"my-app/creds": test.givenSecrets,
+				})
+			}
+			defer vaultSvr.Close()
+
+			ctx := context.Background()
+			c, err := NewClient(ctx, Config{
+				VaultAddress: vaultSvr.URL,
+				LocalToken:   "my-local-token",
+			})
+			if err != nil {
+				t.Fatalf("unable to create client: %s", err)
+			}
+
+			err = c.PatchSecrets(ctx, test.givenPath, test.givenPatch)
+			if err != nil {
+				t.Fatalf("expected no error, got %s", err)
+			}
+
+			var got map[string]interface{}
+			if test.givenKV {
+				got, err = c.GetVersionedSecrets(ctx, test.givenPath)
+			} else {
+				got, err = c.GetSecrets(ctx, test.givenPath)
+			}
+			if err != nil {
+				t.Fatalf("unable to get secrets: %s", err)
+			}
+			if !cmp.Equal(test.wantSecrets, got) {
+				t.Errorf("secrets differ: (-want +got)\n%s", cmp.Diff(test.wantSecrets, got))
+			}
+		})
+	}
+}
+
+func TestPatchSecretsDeletedVersion(t *testing.T) {
+	var gotWrite bool
+	vaultSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			// the latest version has been soft deleted
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": map[string]interface{}{
+					"data": nil,
+					"metadata": map[string]interface{}{
+						"version":       3,
+						"deletion_time": "2024-01-01T00:00:00Z",
+					},
+				},
+			})
+		case http.MethodPatch:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{"unsupported"}})
+		default:
+			gotWrite = true
+			json.NewEncoder(w).Encode(map[string]interface{}{})
+		}
+	}))
+	defer vaultSvr.Close()
+
+	ctx := context.Background()
+	c, err := NewClient(ctx, Config{
+		VaultAddress: vaultSvr.URL,
+		LocalToken:   "my-local-token",
+	})
+	if err != nil {
+		t.Fatalf("unable to create client: %s", err)
+	}
+
+	err = c.PatchSecrets(ctx, "secret/data/my-app/creds", map[string]interface{}{"my-sec": "123"})
+	if !errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("expected ErrSecretNotFound, got %v", err)
+	}
+	if gotWrite {
+		t.Error("expected the deleted version not to be overwritten")
+	}
+}
@@ -0,0 +1,129 @@
+package gcpvault
+
+import (
+	"context"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// renewToken keeps the Client's token alive until ctx is cancelled. The token is
+// renewed for as long as Vault allows it, after which a new login is made and the
+// new token is watched in turn.
+func (c *Client) renewToken(ctx context.Context) {
+	defer close(c.renewalDone)
+
+	// failing renewals are backed off so a token Vault refuses to renew does not turn
+	// into a stream of logins
+	failures := backoff.NewExponentialBackOff()
+	failures.MaxElapsedTime = 0
+
+	for {
+		err := c.watchToken(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			failures.Reset()
+		} else {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(failures.NextBackOff()):
+			}
+		}
+
+		b := backoff.NewExponentialBackOff()
+		b.MaxElapsedTime = 0
+		err = backoff.Retry(func() error {
+			// skip the cache, it holds the token that just ran out
+			return c.login(ctx, false)
+		}, backoff.WithContext(b, ctx))
+		if err != nil {
+			return
+		}
+	}
+}
+
+// watchToken renews the current token until it can no longer be renewed or ctx is
+// cancelled.
+func (c *Client) watchToken(ctx context.Context) error {
+	c.mu.RLock()
+	vClient, token := c.vClient, c.token
+	c.mu.RUnlock()
+
+	if token.Expires.IsZero() {
+		// nothing to renew, wait to be stopped
+		<-ctx.Done()
+		return nil
+	}
+
+	vClient = authClient(vClient, c.cfg)
+	self, err := vClient.Auth().Token().LookupSelfWithContext(ctx)
+	if err != nil {
+		return errors.Wrap(err, "unable to look up token")
+	}
+	renewable, err := self.TokenIsRenewable()
+	if err != nil {
+		return errors.Wrap(err, "unable to check if token is renewable")
+	}
+	ttl, err := self.TokenTTL()
+	if err != nil {
+		return errors.Wrap(err, "unable to retrieve token ttl")
+	}
+
+	behavior := api.RenewBehaviorErrorOnErrors
+	if !renewable {
+		// wait out the token's TTL instead
+		behavior = api.RenewBehaviorRenewDisabled
+	}
+	watcher, err := vClient.NewLifetimeWatcher(&api.LifetimeWatcherInput{
+		Secret: &api.Secret{
+			Auth: &api.SecretAuth{
+				ClientToken:   token.Token,
+				Renewable:     renewable,
+				LeaseDuration: int(ttl.Seconds()),
+			},
+		},
+		RenewBehavior: behavior,
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to create lifetime watcher")
+	}
+	go watcher.Start()
+	defer watcher.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-watcher.DoneCh():
+			return err
+		case renewal := <-watcher.RenewCh():
+			c.renewed(ctx, token.Token, renewal)
+		}
+	}
+}
+
+// renewed records the new expiration of a renewed token and pushes it to the
+// TokenCache.
+func (c *Client) renewed(ctx context.Context, clientToken string, renewal *api.RenewOutput) {
+	if renewal.Secret == nil || renewal.Secret.Auth == nil {
+		return
+	}
+	c.mu.Lock()
+	if c.token.Token != clientToken {
+		// a new login happened in the meantime
+		c.mu.Unlock()
+		return
+	}
+	token := c.token
+	token.Expires = renewal.RenewedAt.Add(time.Second * time.Duration(renewal.Secret.Auth.LeaseDuration))
+	c.token = token
+	c.mu.Unlock()
+
+	// the renewal has already happened so there is nothing to do if the cache fails
+	_ = persistVaultTokenToCache(ctx, c.cfg, token, backoff.NewExponentialBackOff())
+}
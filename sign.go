@@ -0,0 +1,93 @@
+package gcpvault
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/pkg/errors"
+)
+
+// serviceAccountKey holds the fields of a JSON service account key file needed to
+// sign a JWT without the IAM credentials API.
+type serviceAccountKey struct {
+	ClientEmail  string `json:"client_email"`
+	PrivateKeyID string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+}
+
+// newLocalJWT signs the Vault login JWT with the private key of the default
+// credentials, if they came from a service account key file. Keyless credentials,
+// like those of a GCE instance, fall back to signing with the IAM credentials API.
+func newLocalJWT(ctx context.Context, cfg Config) (string, error) {
+	creds, err := findDefaultCredentials(ctx, CloudScope)
+	if err != nil {
+		return "", backoff.Permanent(errors.Wrap(err, "unable to find credentials to sign JWT"))
+	}
+	if len(creds.JSON) == 0 {
+		return newJWTBase(ctx, cfg)
+	}
+
+	var key serviceAccountKey
+	if err := json.Unmarshal(creds.JSON, &key); err != nil {
+		return "", backoff.Permanent(errors.Wrap(err, "unable to parse credentials"))
+	}
+	if key.PrivateKey == "" || key.ClientEmail == "" {
+		return newJWTBase(ctx, cfg)
+	}
+
+	pk, err := parsePrivateKey(key.PrivateKey)
+	if err != nil {
+		return "", backoff.Permanent(errors.Wrap(err, "unable to parse service account private key"))
+	}
+
+	claim, err := newJWTClaim(cfg, key.ClientEmail)
+	if err != nil {
+		return "", err
+	}
+	header, err := json.Marshal(map[string]string{
+		"alg": "RS256",
+		"typ": "JWT",
+		"kid": key.PrivateKeyID,
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "unable to encode JWT header")
+	}
+
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claim)
+	sum := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, pk, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", errors.Wrap(err, "unable to sign JWT")
+	}
+	return unsigned + "." + enc.EncodeToString(sig), nil
+}
+
+// parsePrivateKey decodes a PEM encoded RSA key in either PKCS#8 or PKCS#1 form.
+func parsePrivateKey(key string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(key))
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		pk, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to parse key")
+		}
+		return pk, nil
+	}
+	pk, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not an RSA key")
+	}
+	return pk, nil
+}
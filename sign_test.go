@@ -0,0 +1,116 @@
+package gcpvault
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/iam/v1"
+)
+
+func TestNewLocalJWT(t *testing.T) {
+	pk, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unable to generate key: %s", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(pk)
+	if err != nil {
+		t.Fatalf("unable to encode key: %s", err)
+	}
+	keyFile, _ := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"client_email":   "jp@example.com",
+		"private_key_id": "key-1",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+	})
+
+	tests := []struct {
+		name      string
+		givenJSON []byte
+
+		wantIAMHit bool
+	}{
+		{
+			name:      "key file, signed locally",
+			givenJSON: keyFile,
+		},
+		{
+			name:       "no key, IAM fallback",
+			givenJSON:  []byte(`{"client_email": "jp@example.com", "type": "service_account"}`),
+			wantIAMHit: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var gotIAMHit bool
+			iamSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotIAMHit = true
+				json.NewEncoder(w).Encode(iam.SignJwtResponse{
+					SignedJwt: "gcp-signed-jwt-for-vault",
+				})
+			}))
+			defer iamSvr.Close()
+
+			findDefaultCredentials = func(ctx context.Context, scopes ...string) (*google.Credentials, error) {
+				return &google.Credentials{TokenSource: testTokenSource{}, JSON: test.givenJSON}, nil
+			}
+			defer func() {
+				findDefaultCredentials = google.FindDefaultCredentials
+			}()
+
+			cfg := Config{
+				Role:        "my-gcp-role",
+				SignLocally: true,
+				IAMAddress:  iamSvr.URL,
+			}
+			if err := checkDefaults(&cfg); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			jwt, err := newJWT(context.Background(), cfg)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if test.wantIAMHit != gotIAMHit {
+				t.Errorf("expected IAM hit? %t - got %t", test.wantIAMHit, gotIAMHit)
+			}
+			if test.wantIAMHit {
+				return
+			}
+
+			parts := strings.Split(jwt, ".")
+			if len(parts) != 3 {
+				t.Fatalf("expected 3 JWT segments, got %d", len(parts))
+			}
+			sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+			if err != nil {
+				t.Fatalf("unable to decode signature: %s", err)
+			}
+			sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+			if err := rsa.VerifyPKCS1v15(&pk.PublicKey, crypto.SHA256, sum[:], sig); err != nil {
+				t.Errorf("invalid signature: %s", err)
+			}
+
+			payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+			var claim map[string]interface{}
+			if err := json.Unmarshal(payload, &claim); err != nil {
+				t.Fatalf("unable to decode claim: %s", err)
+			}
+			if claim["aud"] != "vault/my-gcp-role" || claim["sub"] != "jp@example.com" {
+				t.Errorf("unexpected claim: %v", claim)
+			}
+		})
+	}
+}
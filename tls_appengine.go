@@ -0,0 +1,19 @@
+// +build appengine
+
+package gcpvault
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// getVaultHTTPClient returns the urlfetch client, which does not allow the TLS
+// settings to be changed.
+func getVaultHTTPClient(ctx context.Context, cfg Config) (*http.Client, error) {
+	if cfg.hasTLSConfig() {
+		return nil, errors.New("TLS settings are not supported on App Engine")
+	}
+	return getHTTPClient(ctx, cfg), nil
+}
@@ -0,0 +1,141 @@
+// +build !appengine
+
+package gcpvault
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// getVaultHTTPClient returns the HTTP client for requests to Vault, with the TLS
+// settings from cfg applied. A user supplied HTTPClient is used as is.
+func getVaultHTTPClient(ctx context.Context, cfg Config) (*http.Client, error) {
+	if cfg.HTTPClient != nil || !cfg.hasTLSConfig() {
+		return getHTTPClient(ctx, cfg), nil
+	}
+
+	tlsCfg, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			IdleConnTimeout: 1 * time.Second,
+			TLSClientConfig: tlsCfg,
+		},
+	}, nil
+}
+
+func newTLSConfig(cfg Config) (*tls.Config, error) {
+	tlsCfg := &tls.Config{
+		ServerName: cfg.TLSServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if cfg.TLSMinVersion != "" {
+		v, ok := tlsVersions[cfg.TLSMinVersion]
+		if !ok {
+			return nil, errors.Errorf("unsupported TLS version %q", cfg.TLSMinVersion)
+		}
+		tlsCfg.MinVersion = v
+	}
+
+	if cfg.TLSCACert != "" || cfg.TLSCACertBytes != "" {
+		pool := x509.NewCertPool()
+		if cfg.TLSCACert != "" {
+			pem, err := ioutil.ReadFile(cfg.TLSCACert)
+			if err != nil {
+				return nil, errors.Wrap(err, "unable to read CA certificate")
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, errors.Errorf("no certificates found in %s", cfg.TLSCACert)
+			}
+		}
+		if cfg.TLSCACertBytes != "" && !pool.AppendCertsFromPEM([]byte(cfg.TLSCACertBytes)) {
+			return nil, errors.New("no certificates found in CA certificate PEM")
+		}
+		tlsCfg.RootCAs = pool
+	}
+
+	if cfg.TLSClientCert != "" || cfg.TLSClientKey != "" {
+		if cfg.TLSClientCert == "" || cfg.TLSClientKey == "" {
+			return nil, errors.New("both a client certificate and key are required")
+		}
+		r := &certReloader{certFile: cfg.TLSClientCert, keyFile: cfg.TLSClientKey}
+		// fail fast on a bad certificate rather than during the first handshake
+		if _, err := r.GetClientCertificate(nil); err != nil {
+			return nil, err
+		}
+		tlsCfg.GetClientCertificate = r.GetClientCertificate
+	}
+
+	return tlsCfg, nil
+}
+
+var tlsVersions = map[string]uint16{
+	"tls10": tls.VersionTLS10,
+	"tls11": tls.VersionTLS11,
+	"tls12": tls.VersionTLS12,
+	"tls13": tls.VersionTLS13,
+	"1.0":   tls.VersionTLS10,
+	"1.1":   tls.VersionTLS11,
+	"1.2":   tls.VersionTLS12,
+	"1.3":   tls.VersionTLS13,
+}
+
+// certReloader loads a client certificate from disk, loading it again whenever
+// either file changes so rotated certificates are picked up by new connections.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func (r *certReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	modTime, err := latestModTime(r.certFile, r.keyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to stat client certificate")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cert != nil && !modTime.After(r.modTime) {
+		return r.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		if r.cert != nil {
+			// the files may be mid rotation, keep using the last good pair
+			return r.cert, nil
+		}
+		return nil, errors.Wrap(err, "unable to load client certificate")
+	}
+	r.cert = &cert
+	r.modTime = modTime
+	return r.cert, nil
+}
+
+func latestModTime(files ...string) (time.Time, error) {
+	var latest time.Time
+	for _, f := range files {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
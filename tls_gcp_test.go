@@ -0,0 +1,155 @@
+// +build !appengine
+
+package gcpvault
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+func TestVaultMutualTLS(t *testing.T) {
+	clientCAs := x509.NewCertPool()
+	certA, keyA := newTestCert(t, "client-a")
+	certB, keyB := newTestCert(t, "client-b")
+	for _, c := range [][]byte{certA, certB} {
+		clientCAs.AppendCertsFromPEM(c)
+	}
+
+	vaultSvr := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(api.Secret{Data: map[string]interface{}{
+			"client": r.TLS.PeerCertificates[0].Subject.CommonName,
+		}})
+	}))
+	vaultSvr.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	vaultSvr.StartTLS()
+	defer vaultSvr.Close()
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client-key.pem")
+	writeFile(t, caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: vaultSvr.Certificate().Raw}))
+	writeFile(t, certFile, certA)
+	writeFile(t, keyFile, keyA)
+
+	c, err := NewClient(context.Background(), Config{
+		VaultAddress:  vaultSvr.URL,
+		LocalToken:    "my-local-token",
+		TLSCACert:     caFile,
+		TLSClientCert: certFile,
+		TLSClientKey:  keyFile,
+		TLSServerName: "example.com",
+		TLSMinVersion: "tls12",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer c.Close()
+
+	secrets, err := c.GetSecrets(context.Background(), "my-secret-path")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if secrets["client"] != "client-a" {
+		t.Errorf("expected the client-a certificate, got %v", secrets["client"])
+	}
+
+	// rotate the certificate and force a new connection
+	writeFile(t, certFile, certB)
+	writeFile(t, keyFile, keyB)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	os.Chtimes(keyFile, later, later)
+	vaultSvr.CloseClientConnections()
+
+	secrets, err = c.GetSecrets(context.Background(), "my-secret-path")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if secrets["client"] != "client-b" {
+		t.Errorf("expected the rotated client-b certificate, got %v", secrets["client"])
+	}
+}
+
+func TestNewTLSConfigErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		givenCfg Config
+	}{
+		{
+			name:     "missing CA file",
+			givenCfg: Config{TLSCACert: filepath.Join(t.TempDir(), "missing.pem")},
+		},
+		{
+			name:     "bad CA PEM",
+			givenCfg: Config{TLSCACertBytes: "not a certificate"},
+		},
+		{
+			name:     "cert without key",
+			givenCfg: Config{TLSClientCert: "client.pem"},
+		},
+		{
+			name:     "unknown TLS version",
+			givenCfg: Config{TLSMinVersion: "ssl3"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := newTLSConfig(test.givenCfg); err == nil {
+				t.Error("expected error, got none")
+			}
+		})
+	}
+}
+
+func newTestCert(t *testing.T, name string) (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, name string, data []byte) {
+	if err := ioutil.WriteFile(name, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
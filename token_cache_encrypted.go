@@ -0,0 +1,231 @@
+package gcpvault
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// KeyWrapper encrypts and decrypts the keys that cached tokens are encrypted with.
+// Implementations are available for Cloud KMS and local AES keys, and others, such
+// as one using Vault's transit engine, can be plugged in with
+// Config.TokenCacheKeyWrapper.
+type KeyWrapper interface {
+	// WrapKey encrypts key, returning it with the ID of the key version used.
+	WrapKey(ctx context.Context, key []byte) (wrapped []byte, keyID string, err error)
+	// UnwrapKey decrypts a key that was wrapped with the key version keyID.
+	UnwrapKey(ctx context.Context, wrapped []byte, keyID string) ([]byte, error)
+}
+
+// errUnknownKey is returned by a KeyWrapper for keys it no longer has.
+var errUnknownKey = errors.New("unknown token cache key")
+
+// TokenCacheEncrypted encrypts tokens before saving them to another TokenCache.
+// Each token is encrypted with a new AES-256-GCM key, which is wrapped by Keys and
+// stored alongside the token with the ID of the key version that wrapped it, so keys
+// can be rotated without invalidating the cache. The expiration and address of the
+// token are not encrypted, but are authenticated with it.
+//
+// Tokens that were cached without encryption or with a key that is no longer
+// known are treated as missing, so a new login replaces them.
+type TokenCacheEncrypted struct {
+	Cache TokenCache
+	Keys  KeyWrapper
+}
+
+// encryptedTokenPrefix marks an encrypted token in a cached Token.
+const encryptedTokenPrefix = "gcpvault:v1:"
+
+type encryptedToken struct {
+	KeyID string `json:"key_id"`
+	Key   []byte `json:"key"`
+	Data  []byte `json:"data"`
+}
+
+func (t TokenCacheEncrypted) GetToken(ctx context.Context) (*Token, error) {
+	token, err := t.Cache.GetToken(ctx)
+	if err != nil || token == nil {
+		return token, err
+	}
+	if !strings.HasPrefix(token.Token, encryptedTokenPrefix) {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(token.Token, encryptedTokenPrefix))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to decode encrypted token")
+	}
+	var enc encryptedToken
+	if err := json.Unmarshal(data, &enc); err != nil {
+		return nil, errors.Wrap(err, "unable to decode encrypted token")
+	}
+
+	key, err := t.Keys.UnwrapKey(ctx, enc.Key, enc.KeyID)
+	if err == errUnknownKey {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to decrypt token key %q", enc.KeyID)
+	}
+	plain, err := openAESGCM(key, enc.Data, tokenAdditionalData(*token))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to decrypt token")
+	}
+	token.Token = string(plain)
+	return token, nil
+}
+
+func (t TokenCacheEncrypted) SaveToken(ctx context.Context, token Token) error {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return errors.Wrap(err, "unable to generate token key")
+	}
+	data, err := sealAESGCM(key, []byte(token.Token), tokenAdditionalData(token))
+	if err != nil {
+		return errors.Wrap(err, "unable to encrypt token")
+	}
+	wrapped, keyID, err := t.Keys.WrapKey(ctx, key)
+	if err != nil {
+		return errors.Wrap(err, "unable to encrypt token key")
+	}
+
+	payload, err := json.Marshal(encryptedToken{KeyID: keyID, Key: wrapped, Data: data})
+	if err != nil {
+		return errors.Wrap(err, "error marshalling data")
+	}
+	token.Token = encryptedTokenPrefix + base64.RawURLEncoding.EncodeToString(payload)
+	return t.Cache.SaveToken(ctx, token)
+}
+
+// LockToken takes the lock on the underlying cache, if it supports one.
+func (t TokenCacheEncrypted) LockToken(ctx context.Context, ttl time.Duration) (func(), bool, error) {
+	return lockToken(ctx, t.Cache, ttl)
+}
+
+// encryptTokenCache wraps cache in a TokenCacheEncrypted using keys. Caches that
+// already encrypt their tokens are returned as they are, and only the shared cache
+// of a TokenCacheLayered is wrapped, as tokens kept in memory never leave the
+// process.
+func encryptTokenCache(cache TokenCache, keys KeyWrapper) TokenCache {
+	switch c := cache.(type) {
+	case TokenCacheEncrypted, memoryTokenCache:
+		return cache
+	case TokenCacheLayered:
+		c.shared = encryptTokenCache(c.shared, keys)
+		return c
+	}
+	return TokenCacheEncrypted{Cache: cache, Keys: keys}
+}
+
+// tokenAdditionalData ties an encrypted token to the expiration and address it
+// was cached with.
+func tokenAdditionalData(token Token) []byte {
+	return []byte(token.Expires.UTC().Format(time.RFC3339Nano) + "|" + token.Address)
+}
+
+// sealAESGCM encrypts plaintext with key, prefixing it with a random nonce.
+func sealAESGCM(key, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := newAESGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// openAESGCM decrypts ciphertext created by sealAESGCM.
+func openAESGCM(key, ciphertext, additionalData []byte) ([]byte, error) {
+	aead, err := newAESGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// NewAESKeyWrapper returns a KeyWrapper that wraps keys with a locally supplied
+// 16, 24 or 32 byte AES key. Keys that tokens were previously cached with can be
+// given as well, so they can still be read after key is rotated.
+func NewAESKeyWrapper(key []byte, previous ...[]byte) (KeyWrapper, error) {
+	w := aesKeyWrapper{keys: map[string][]byte{}}
+	for i, k := range append([][]byte{key}, previous...) {
+		if _, err := aes.NewCipher(k); err != nil {
+			return nil, errors.Wrap(err, "invalid token cache encryption key")
+		}
+		id := aesKeyID(k)
+		if i == 0 {
+			w.primary = id
+		}
+		w.keys[id] = k
+	}
+	return w, nil
+}
+
+type aesKeyWrapper struct {
+	primary string
+	keys    map[string][]byte
+}
+
+// aesKeyID identifies a local key by its fingerprint, so no names need to be
+// configured for it.
+func aesKeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return "aes:" + hex.EncodeToString(sum[:8])
+}
+
+func (w aesKeyWrapper) WrapKey(ctx context.Context, key []byte) ([]byte, string, error) {
+	wrapped, err := sealAESGCM(w.keys[w.primary], key, []byte(w.primary))
+	return wrapped, w.primary, err
+}
+
+func (w aesKeyWrapper) UnwrapKey(ctx context.Context, wrapped []byte, keyID string) ([]byte, error) {
+	k, ok := w.keys[keyID]
+	if !ok {
+		return nil, errUnknownKey
+	}
+	return openAESGCM(k, wrapped, []byte(keyID))
+}
+
+// newKeyWrapper returns the KeyWrapper for the token cache encryption settings in
+// cfg, or nil if none are set.
+func newKeyWrapper(cfg *Config) (KeyWrapper, error) {
+	if cfg.TokenCacheKMSKey != "" {
+		return kmsKeyWrapper{cfg: cfg}, nil
+	}
+	if cfg.TokenCacheEncryptionKey == "" {
+		return nil, nil
+	}
+	var keys [][]byte
+	for _, k := range append([]string{cfg.TokenCacheEncryptionKey}, cfg.TokenCacheDecryptionKeys...) {
+		key, err := base64.StdEncoding.DecodeString(k)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to decode token cache encryption key")
+		}
+		keys = append(keys, key)
+	}
+	return NewAESKeyWrapper(keys[0], keys[1:]...)
+}
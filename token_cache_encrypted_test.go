@@ -0,0 +1,238 @@
+package gcpvault
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NYTimes/gcp-vault/gcpvaulttest"
+	"golang.org/x/oauth2/google"
+)
+
+// tokenCacheStore keeps the last saved token, as a shared cache would.
+type tokenCacheStore struct {
+	token *Token
+}
+
+func (t *tokenCacheStore) GetToken(ctx context.Context) (*Token, error) {
+	if t.token == nil {
+		return nil, nil
+	}
+	token := *t.token
+	return &token, nil
+}
+
+func (t *tokenCacheStore) SaveToken(ctx context.Context, token Token) error {
+	t.token = &token
+	return nil
+}
+
+func TestTokenCacheEncrypted(t *testing.T) {
+	kmsSvr := gcpvaulttest.NewKMSServer()
+	defer kmsSvr.Close()
+
+	findDefaultCredentials = func(ctx context.Context, scopes ...string) (*google.Credentials, error) {
+		return &google.Credentials{TokenSource: testTokenSource{}}, nil
+	}
+	defer func() {
+		findDefaultCredentials = google.FindDefaultCredentials
+	}()
+
+	oldKey := bytes.Repeat([]byte{1}, 32)
+	newKey := bytes.Repeat([]byte{2}, 32)
+	kmsKey := "projects/p/locations/global/keyRings/r/cryptoKeys/tokens"
+	mustAES := func(key []byte, previous ...[]byte) KeyWrapper {
+		w, err := NewAESKeyWrapper(key, previous...)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return w
+	}
+
+	tests := []struct {
+		name      string
+		givenSave KeyWrapper
+		givenRead KeyWrapper
+		givenRaw  bool
+
+		wantKeyID string
+		wantToken bool
+	}{
+		{
+			name:      "local key",
+			givenSave: mustAES(oldKey),
+			givenRead: mustAES(oldKey),
+
+			wantKeyID: aesKeyID(oldKey),
+			wantToken: true,
+		},
+		{
+			name:      "rotated local key",
+			givenSave: mustAES(oldKey),
+			givenRead: mustAES(newKey, oldKey),
+
+			wantKeyID: aesKeyID(oldKey),
+			wantToken: true,
+		},
+		{
+			name:      "retired local key",
+			givenSave: mustAES(oldKey),
+			givenRead: mustAES(newKey),
+
+			wantKeyID: aesKeyID(oldKey),
+		},
+		{
+			name:      "KMS key",
+			givenSave: NewKMSKeyWrapper(Config{TokenCacheKMSKey: kmsKey, KMSAddress: kmsSvr.URL}),
+			givenRead: NewKMSKeyWrapper(Config{TokenCacheKMSKey: kmsKey, KMSAddress: kmsSvr.URL}),
+
+			wantKeyID: kmsKey + "/cryptoKeyVersions/1",
+			wantToken: true,
+		},
+		{
+			name:      "other KMS key",
+			givenSave: NewKMSKeyWrapper(Config{TokenCacheKMSKey: kmsKey + "-old", KMSAddress: kmsSvr.URL}),
+			givenRead: NewKMSKeyWrapper(Config{TokenCacheKMSKey: kmsKey, KMSAddress: kmsSvr.URL}),
+
+			wantKeyID: kmsKey + "-old/cryptoKeyVersions/1",
+		},
+		{
+			name:      "unencrypted token",
+			givenRead: mustAES(oldKey),
+			givenRaw:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := &tokenCacheStore{}
+			want := Token{Token: "vault-test-token", Expires: time.Now().Add(time.Hour)}
+
+			if test.givenRaw {
+				store.SaveToken(ctx, want)
+			} else {
+				err := TokenCacheEncrypted{Cache: store, Keys: test.givenSave}.SaveToken(ctx, want)
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				if strings.Contains(store.token.Token, want.Token) {
+					t.Errorf("expected the cached token to be encrypted, got %q", store.token.Token)
+				}
+				if !strings.HasPrefix(store.token.Token, encryptedTokenPrefix) {
+					t.Errorf("expected an encrypted token, got %q", store.token.Token)
+				}
+				if got := decodeEncryptedToken(t, store.token.Token).KeyID; got != test.wantKeyID {
+					t.Errorf("expected key ID %q to be cached, got %q", test.wantKeyID, got)
+				}
+			}
+
+			got, err := TokenCacheEncrypted{Cache: store, Keys: test.givenRead}.GetToken(ctx)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !test.wantToken {
+				if got != nil {
+					t.Errorf("expected no token, got %+v", got)
+				}
+				return
+			}
+			if got == nil || got.Token != want.Token || !got.Expires.Equal(want.Expires) {
+				t.Errorf("expected token %+v, got %+v", want, got)
+			}
+		})
+	}
+}
+
+func TestTokenCacheEncryptedTampering(t *testing.T) {
+	ctx := context.Background()
+	keys, err := NewAESKeyWrapper(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	store := &tokenCacheStore{}
+	cache := TokenCacheEncrypted{Cache: store, Keys: keys}
+	err = cache.SaveToken(ctx, Token{Token: "vault-test-token", Expires: time.Now().Add(time.Minute)})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// extending the expiration must not go unnoticed
+	store.token.Expires = store.token.Expires.Add(24 * time.Hour)
+	if _, err := cache.GetToken(ctx); err == nil {
+		t.Error("expected an error for a modified token")
+	}
+}
+
+func TestTokenCacheEncryptedConfig(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+
+	cfg := Config{
+		TokenCacheStorageFile:   filepath.Join(t.TempDir(), "vault"),
+		TokenCacheEncryptionKey: key,
+	}
+	if err := checkDefaults(&cfg); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	layered, ok := cfg.TokenCache.(TokenCacheLayered)
+	if !ok {
+		t.Fatalf("expected a TokenCacheLayered, got %T", cfg.TokenCache)
+	}
+	if _, ok := layered.shared.(TokenCacheEncrypted); !ok {
+		t.Errorf("expected the shared cache to be encrypted, got %T", layered.shared)
+	}
+
+	// a custom cache is encrypted too, and only once
+	store := &tokenCacheStore{}
+	cfg = Config{
+		TokenCache:              store,
+		TokenCacheEncryptionKey: key,
+	}
+	for i := 0; i < 2; i++ {
+		if err := checkDefaults(&cfg); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	encrypted, ok := cfg.TokenCache.(TokenCacheEncrypted)
+	if !ok || encrypted.Cache != store {
+		t.Fatalf("expected the custom cache to be encrypted once, got %#v", cfg.TokenCache)
+	}
+	err := cfg.TokenCache.SaveToken(context.Background(), Token{Token: "vault-test-token"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !strings.HasPrefix(store.token.Token, encryptedTokenPrefix) {
+		t.Errorf("expected the custom cache to hold an encrypted token, got %q", store.token.Token)
+	}
+
+	cfg = Config{
+		TokenCacheStorageFile:   t.TempDir(),
+		TokenCacheEncryptionKey: key,
+		TokenCacheKMSKey:        "projects/p/locations/global/keyRings/r/cryptoKeys/tokens",
+	}
+	if err := checkDefaults(&cfg); err == nil {
+		t.Error("expected an error when both keys are set")
+	}
+
+	cfg = Config{TokenCacheEncryptionKey: "bm90IGEga2V5"}
+	if err := checkDefaults(&cfg); err == nil {
+		t.Error("expected an error for an invalid key")
+	}
+}
+
+func decodeEncryptedToken(t *testing.T, token string) encryptedToken {
+	var enc encryptedToken
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(token, encryptedTokenPrefix))
+	if err == nil {
+		err = json.Unmarshal(data, &enc)
+	}
+	if err != nil {
+		t.Fatalf("unable to decode encrypted token: %s", err)
+	}
+	return enc
+}
@@ -0,0 +1,88 @@
+package gcpvault
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TokenCacheFile stores the Vault token as JSON in a file named after the
+// TokenCacheKeyName within the TokenCacheStorageFile directory. The file is only
+// readable by its owner and is replaced atomically. Processes on the same host take
+// an advisory lock on it before logging in, so only one of them logs in while the
+// others wait to read its token.
+type TokenCacheFile struct {
+	cfg *Config
+}
+
+func (t TokenCacheFile) path() string {
+	return filepath.Join(t.cfg.TokenCacheStorageFile, filepath.FromSlash(tokenCacheKey(t.cfg)))
+}
+
+func (t TokenCacheFile) GetToken(ctx context.Context) (*Token, error) {
+	data, err := ioutil.ReadFile(t.path())
+	if os.IsNotExist(err) {
+		// we may not have cached a token yet
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading")
+	}
+	var token Token
+	err = json.Unmarshal(data, &token)
+	if err != nil {
+		return nil, errors.Wrap(err, "error unmarshalling data")
+	}
+	return &token, nil
+}
+
+func (t TokenCacheFile) SaveToken(ctx context.Context, token Token) error {
+	path := t.path()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return errors.Wrap(err, "unable to create token cache directory")
+	}
+	payload, err := json.Marshal(&token)
+	if err != nil {
+		return errors.Wrap(err, "error marshalling data")
+	}
+
+	// write to a temporary file and rename it so readers never see a partial token
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "unable to create temporary file")
+	}
+	defer os.Remove(f.Name())
+
+	// TempFile creates files with 0600 permissions already, but be explicit
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		return errors.Wrap(err, "unable to set token cache permissions")
+	}
+	if _, err := f.Write(payload); err != nil {
+		f.Close()
+		return errors.Wrap(err, "error writing")
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return errors.Wrap(err, "error writing")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "error writing")
+	}
+	return errors.Wrap(os.Rename(f.Name(), path), "unable to replace token cache")
+}
+
+// LockToken takes an advisory lock held for as long as this process is logging in.
+// The lock is released by the system if the process exits, so ttl is not needed.
+func (t TokenCacheFile) LockToken(ctx context.Context, ttl time.Duration) (func(), bool, error) {
+	unlock, ok, err := tryLockFile(t.path() + ".login")
+	if err != nil {
+		return nil, false, errors.Wrap(err, "unable to lock token cache")
+	}
+	return unlock, ok, nil
+}
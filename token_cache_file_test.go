@@ -0,0 +1,144 @@
+package gcpvault
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+func TestTokenCacheFile(t *testing.T) {
+	cfg := Config{
+		TokenCacheStorageFile: filepath.Join(t.TempDir(), "vault"),
+		Namespace:             "admin",
+	}
+	if err := checkDefaults(&cfg); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	layered, ok := cfg.TokenCache.(TokenCacheLayered)
+	if !ok {
+		t.Fatalf("expected a TokenCacheLayered, got %T", cfg.TokenCache)
+	}
+	cache, ok := layered.shared.(TokenCacheFile)
+	if !ok {
+		t.Fatalf("expected a TokenCacheFile, got %T", layered.shared)
+	}
+
+	ctx := context.Background()
+	token, err := cache.GetToken(ctx)
+	if err != nil || token != nil {
+		t.Fatalf("expected no token yet, got %v, %v", token, err)
+	}
+
+	want := Token{Token: "vault-test-token", Expires: time.Now().Add(time.Hour).Round(0)}
+	if err := cache.SaveToken(ctx, want); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	path := filepath.Join(cfg.TokenCacheStorageFile, "token-cache", "admin")
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("expected token file at %s: %s", path, err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("expected 0600 permissions, got %o", fi.Mode().Perm())
+	}
+
+	token, err = cache.GetToken(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if token.Token != want.Token || !token.Expires.Equal(want.Expires) {
+		t.Errorf("expected token %+v, got %+v", want, token)
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "*.tmp*"))
+	if len(matches) != 0 {
+		t.Errorf("expected temporary files to be cleaned up, got %v", matches)
+	}
+}
+
+func TestTokenCacheFileSharesLogin(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		gotLogins int
+	)
+	vaultSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/approle/login":
+			mu.Lock()
+			gotLogins++
+			mu.Unlock()
+			time.Sleep(200 * time.Millisecond)
+			json.NewEncoder(w).Encode(api.Secret{
+				Auth: &api.SecretAuth{ClientToken: "vault-test-token", LeaseDuration: 3600},
+			})
+		default:
+			json.NewEncoder(w).Encode(api.Secret{Data: map[string]interface{}{"ttl": 3600}})
+		}
+	}))
+	defer vaultSvr.Close()
+
+	// each client stands in for a separate process, so they use different role
+	// IDs to keep them from sharing a login in memory
+	dir := t.TempDir()
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c, err := NewClient(context.Background(), Config{
+				VaultAddress:          vaultSvr.URL,
+				AuthType:              AuthTypeAppRole,
+				AppRoleID:             fmt.Sprintf("my-role-id-%d", i),
+				TokenCacheStorageFile: dir,
+			})
+			if err != nil {
+				errs <- err
+				return
+			}
+			c.Close()
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if gotLogins != 1 {
+		t.Errorf("expected 1 login, got %d", gotLogins)
+	}
+}
+
+func TestTokenCacheFileLock(t *testing.T) {
+	cfg := Config{TokenCacheStorageFile: t.TempDir()}
+	if err := checkDefaults(&cfg); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	cache := TokenCacheFile{cfg: &cfg}
+
+	ctx := context.Background()
+	unlock, ok, err := cache.LockToken(ctx, time.Minute)
+	if err != nil || !ok {
+		t.Fatalf("expected to take the lock, got %v, %v", ok, err)
+	}
+	if _, ok, _ := cache.LockToken(ctx, time.Minute); ok {
+		t.Error("expected the lock to be held")
+	}
+	unlock()
+
+	unlock, ok, err = cache.LockToken(ctx, time.Minute)
+	if err != nil || !ok {
+		t.Fatalf("expected to take the released lock, got %v, %v", ok, err)
+	}
+	unlock()
+}
@@ -5,8 +5,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"time"
 
 	"cloud.google.com/go/storage"
+	"github.com/pkg/errors"
+	"google.golang.org/api/googleapi"
 )
 
 type TokenCacheGCS struct {
@@ -17,7 +21,7 @@ func (t TokenCacheGCS) GetToken(ctx context.Context) (*Token, error) {
 
 	if t.cfg.TokenCache != nil {
 		bucket := t.cfg.TokenCacheStorageGCS
-		object := t.cfg.TokenCacheKeyName
+		object := tokenCacheKey(t.cfg)
 		client, err := storage.NewClient(ctx)
 		if err != nil {
 			return nil, fmt.Errorf("error creating new storage client: %v", err)
@@ -52,7 +56,7 @@ func (t TokenCacheGCS) SaveToken(ctx context.Context, token Token) error {
 	if t.cfg.TokenCache != nil {
 
 		bucket := t.cfg.TokenCacheStorageGCS
-		object := t.cfg.TokenCacheKeyName
+		object := tokenCacheKey(t.cfg)
 
 		client, err := storage.NewClient(ctx)
 		if err != nil {
@@ -78,3 +82,50 @@ func (t TokenCacheGCS) SaveToken(ctx context.Context, token Token) error {
 
 	return nil
 }
+
+// LockToken creates a lock object next to the token with a precondition that it
+// does not exist yet. Lock objects older than ttl were left behind by instances that
+// stopped before releasing them and are replaced.
+func (t TokenCacheGCS) LockToken(ctx context.Context, ttl time.Duration) (func(), bool, error) {
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("error creating new storage client: %v", err)
+	}
+	obj := client.Bucket(t.cfg.TokenCacheStorageGCS).Object(tokenCacheKey(t.cfg) + ".lock")
+
+	gen, err := createGCSLock(ctx, obj)
+	if isPreconditionFailed(err) {
+		attrs, aerr := obj.Attrs(ctx)
+		if aerr == nil && time.Since(attrs.Created) > ttl {
+			// only delete the stale lock, not one another instance just replaced it with
+			obj.If(storage.Conditions{GenerationMatch: attrs.Generation}).Delete(ctx)
+			gen, err = createGCSLock(ctx, obj)
+		}
+	}
+	if isPreconditionFailed(err) {
+		client.Close()
+		return nil, false, nil
+	}
+	if err != nil {
+		client.Close()
+		return nil, false, fmt.Errorf("error locking: %v", err)
+	}
+	return func() {
+		obj.If(storage.Conditions{GenerationMatch: gen}).Delete(ctx)
+		client.Close()
+	}, true, nil
+}
+
+// createGCSLock creates obj if it does not exist, returning its generation.
+func createGCSLock(ctx context.Context, obj *storage.ObjectHandle) (int64, error) {
+	wc := obj.If(storage.Conditions{DoesNotExist: true}).NewWriter(ctx)
+	if err := wc.Close(); err != nil {
+		return 0, err
+	}
+	return wc.Attrs().Generation, nil
+}
+
+func isPreconditionFailed(err error) bool {
+	var gErr *googleapi.Error
+	return errors.As(err, &gErr) && gErr.Code == http.StatusPreconditionFailed
+}
@@ -0,0 +1,71 @@
+package gcpvault
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// TokenCacheLayered keeps tokens in memory in front of a cache shared with other
+// processes, such as TokenCacheGCS, TokenCacheRedis or TokenCacheFile. Tokens are
+// read from the shared cache only when the one in memory is missing or due to be
+// refreshed, and saved to both. It is used whenever one of those caches is
+// configured for a GCP, Kubernetes or AppRole login, and can be put in front of a
+// custom TokenCache with NewTokenCacheLayered.
+type TokenCacheLayered struct {
+	cfg    *Config
+	local  TokenCache
+	shared TokenCache
+}
+
+// layeredCaches numbers the TokenCacheLayered whose login is unknown, so each one
+// keeps its tokens in memory apart from the others.
+var layeredCaches int64
+
+// NewTokenCacheLayered returns a TokenCacheLayered that keeps tokens in memory in
+// front of shared. The refresh settings of cfg decide when the token in memory is
+// read from shared again, and the address and credentials of cfg, once an
+// AuthMethod is set, let caches for the same login share the tokens in memory. cfg
+// is not used after the cache is created.
+func NewTokenCacheLayered(cfg *Config, shared TokenCache) TokenCacheLayered {
+	c := *cfg
+	checkRefreshDefaults(&c)
+	key := loginKey(c)
+	if key == "" {
+		key = fmt.Sprintf("layered|%d", atomic.AddInt64(&layeredCaches, 1))
+	}
+	return TokenCacheLayered{cfg: &c, local: memoryTokenCache{key: key}, shared: shared}
+}
+
+// verifiedTokenCache is implemented by caches that want to know when a token they
+// returned has been checked with Vault.
+type verifiedTokenCache interface {
+	markVerified(ctx context.Context, token Token)
+}
+
+func (t TokenCacheLayered) GetToken(ctx context.Context) (*Token, error) {
+	token, err := t.local.GetToken(ctx)
+	if err == nil && !isExpired(token, *t.cfg) {
+		return token, nil
+	}
+	return t.shared.GetToken(ctx)
+}
+
+func (t TokenCacheLayered) SaveToken(ctx context.Context, token Token) error {
+	if err := t.shared.SaveToken(ctx, token); err != nil {
+		return err
+	}
+	return t.local.SaveToken(ctx, token)
+}
+
+// LockToken takes the lock on the shared cache, if it supports one.
+func (t TokenCacheLayered) LockToken(ctx context.Context, ttl time.Duration) (func(), bool, error) {
+	return lockToken(ctx, t.shared, ttl)
+}
+
+// markVerified keeps a token read from the shared cache in memory once Vault has
+// confirmed it is still valid, so it is not looked up again.
+func (t TokenCacheLayered) markVerified(ctx context.Context, token Token) {
+	t.local.SaveToken(ctx, token)
+}
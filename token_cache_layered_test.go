@@ -0,0 +1,130 @@
+package gcpvault
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+func TestTokenCacheLayered(t *testing.T) {
+	var gotLogins, gotLookups int
+	vaultSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/approle/login":
+			gotLogins++
+			json.NewEncoder(w).Encode(api.Secret{
+				Auth: &api.SecretAuth{ClientToken: "vault-test-token", LeaseDuration: 3600},
+			})
+		case "/v1/auth/token/lookup-self":
+			gotLookups++
+			json.NewEncoder(w).Encode(api.Secret{Data: map[string]interface{}{"ttl": 3600}})
+		default:
+			json.NewEncoder(w).Encode(api.Secret{Data: map[string]interface{}{"my-sec": "123"}})
+		}
+	}))
+	defer vaultSvr.Close()
+
+	cfg := Config{
+		VaultAddress:          vaultSvr.URL,
+		AuthType:              AuthTypeAppRole,
+		AppRoleID:             "my-role-id",
+		SecretPath:            "my-secret-path",
+		TokenCacheStorageFile: t.TempDir(),
+	}
+
+	// another process has already logged in and shared its token
+	shared := cfg
+	if err := checkDefaults(&shared); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	err := TokenCacheFile{cfg: &shared}.SaveToken(context.Background(), Token{
+		Token:   "shared-vault-token",
+		Expires: time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := GetSecrets(context.Background(), cfg); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	if gotLogins != 0 {
+		t.Errorf("expected the shared token to be used, got %d logins", gotLogins)
+	}
+	if gotLookups != 1 {
+		t.Errorf("expected the shared token to be looked up once, got %d lookups", gotLookups)
+	}
+}
+
+func TestTokenCacheLayeredRefresh(t *testing.T) {
+	cfg := Config{VaultAddress: "http://vault.example.com"}
+	if err := checkDefaults(&cfg); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	ctx := context.Background()
+	local := memoryTokenCache{key: t.Name()}
+	local.SaveToken(ctx, Token{Token: "expiring-token", Expires: time.Now().Add(time.Second)})
+	shared := TokenCacheMock{Token: Token{Token: "fresh-token", Expires: time.Now().Add(time.Hour)}}
+
+	token, err := TokenCacheLayered{cfg: &cfg, local: local, shared: shared}.GetToken(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if token.Token != "fresh-token" {
+		t.Errorf("expected the token from the shared cache, got %q", token.Token)
+	}
+}
+
+func TestNewTokenCacheLayered(t *testing.T) {
+	var gotLogins, gotLookups int
+	vaultSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/approle/login":
+			gotLogins++
+			json.NewEncoder(w).Encode(api.Secret{
+				Auth: &api.SecretAuth{ClientToken: "vault-test-token", LeaseDuration: 3600},
+			})
+		case "/v1/auth/token/lookup-self":
+			gotLookups++
+			json.NewEncoder(w).Encode(api.Secret{Data: map[string]interface{}{"ttl": 3600}})
+		default:
+			json.NewEncoder(w).Encode(api.Secret{Data: map[string]interface{}{"my-sec": "123"}})
+		}
+	}))
+	defer vaultSvr.Close()
+
+	// a custom shared cache that another process has already saved a token to
+	shared := &tokenCacheStore{token: &Token{
+		Token:   "shared-vault-token",
+		Expires: time.Now().Add(time.Hour),
+	}}
+	cfg := Config{
+		VaultAddress: vaultSvr.URL,
+		AuthType:     AuthTypeAppRole,
+		AppRoleID:    "my-role-id",
+		SecretPath:   "my-secret-path",
+	}
+	cfg.TokenCache = NewTokenCacheLayered(&cfg, shared)
+
+	for i := 0; i < 3; i++ {
+		if _, err := GetSecrets(context.Background(), cfg); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	if gotLogins != 0 {
+		t.Errorf("expected the shared token to be used, got %d logins", gotLogins)
+	}
+	if gotLookups != 1 {
+		t.Errorf("expected the shared token to be looked up once, got %d lookups", gotLookups)
+	}
+}
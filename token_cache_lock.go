@@ -0,0 +1,72 @@
+package gcpvault
+
+import (
+	"context"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+// TokenCacheLocker is implemented by TokenCaches that can coordinate logins
+// between the instances sharing them. TokenCacheGCS, TokenCacheRedis and
+// TokenCacheFile implement it. It is used when Config.TokenCacheLock is set, and
+// always for a TokenCacheStorageFile.
+type TokenCacheLocker interface {
+	// LockToken tries to take the lock on the cached token, reporting false if
+	// another instance holds it. The lock expires after ttl if it is not released
+	// with the returned func.
+	LockToken(ctx context.Context, ttl time.Duration) (unlock func(), ok bool, err error)
+}
+
+// lockPollInterval is how often an instance waiting for another's login checks the
+// cache for the new token.
+var lockPollInterval = 250 * time.Millisecond
+
+// lockToken takes the lock on cache, or succeeds right away if it does not support
+// locking.
+func lockToken(ctx context.Context, cache TokenCache, ttl time.Duration) (func(), bool, error) {
+	locker, ok := cache.(TokenCacheLocker)
+	if !ok {
+		return func() {}, true, nil
+	}
+	return locker.LockToken(ctx, ttl)
+}
+
+// waitForLogin takes the lock on the token cache, returning the func that releases
+// it once the caller has logged in and saved the new token. If another instance
+// holds the lock, it waits for that instance to save a token and returns the token
+// instead. When the lock cannot be taken within the TokenCacheLockTimeout, or does
+// not work at all, the caller logs in without it.
+func waitForLogin(ctx context.Context, cfg Config, b *backoff.ExponentialBackOff) (Token, func()) {
+	timeout := time.Second * time.Duration(cfg.TokenCacheLockTimeout)
+	deadline := time.Now().Add(timeout)
+	for {
+		unlock, ok, err := lockToken(ctx, cfg.TokenCache, timeout)
+		if err != nil {
+			return Token{}, func() {}
+		}
+		if ok {
+			// the previous holder may have saved a token since we last looked
+			token, err := getVaultTokenFromCache(ctx, cfg, b)
+			if err == nil && token.Token != "" {
+				unlock()
+				return token, func() {}
+			}
+			return Token{}, unlock
+		}
+
+		if time.Now().After(deadline) {
+			return Token{}, func() {}
+		}
+		select {
+		case <-ctx.Done():
+			return Token{}, func() {}
+		case <-time.After(lockPollInterval):
+		}
+
+		token, err := getVaultTokenFromCache(ctx, cfg, b)
+		if err == nil && token.Token != "" {
+			return token, func() {}
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package gcpvault
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// lockingTokenCache is a shared cache whose lock may be held by another instance.
+type lockingTokenCache struct {
+	mu     sync.Mutex
+	token  *Token
+	held   bool
+	locked int
+}
+
+func (t *lockingTokenCache) GetToken(ctx context.Context) (*Token, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token == nil {
+		return nil, nil
+	}
+	token := *t.token
+	return &token, nil
+}
+
+func (t *lockingTokenCache) SaveToken(ctx context.Context, token Token) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.token = &token
+	return nil
+}
+
+func (t *lockingTokenCache) LockToken(ctx context.Context, ttl time.Duration) (func(), bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.held {
+		return nil, false, nil
+	}
+	t.held = true
+	t.locked++
+	return func() {
+		t.mu.Lock()
+		t.held = false
+		t.mu.Unlock()
+	}, true, nil
+}
+
+func TestTokenCacheLock(t *testing.T) {
+	tests := []struct {
+		name string
+
+		givenHeld      bool
+		givenOtherSave bool
+
+		wantLogins int
+		wantLocked int
+	}{
+		{
+			name: "lock is free",
+
+			wantLogins: 1,
+			wantLocked: 1,
+		},
+		{
+			name: "another instance logs in",
+
+			givenHeld:      true,
+			givenOtherSave: true,
+		},
+		{
+			name: "lock is never released",
+
+			givenHeld: true,
+
+			wantLogins: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				mu        sync.Mutex
+				gotLogins int
+			)
+			vaultSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/v1/auth/approle/login":
+					mu.Lock()
+					gotLogins++
+					mu.Unlock()
+					json.NewEncoder(w).Encode(api.Secret{
+						Auth: &api.SecretAuth{ClientToken: "vault-test-token", LeaseDuration: 3600},
+					})
+				case "/v1/auth/token/lookup-self":
+					json.NewEncoder(w).Encode(api.Secret{Data: map[string]interface{}{"ttl": 3600}})
+				default:
+					json.NewEncoder(w).Encode(api.Secret{Data: map[string]interface{}{"my-sec": "123"}})
+				}
+			}))
+			defer vaultSvr.Close()
+
+			cache := &lockingTokenCache{held: test.givenHeld}
+			if test.givenOtherSave {
+				go func() {
+					time.Sleep(300 * time.Millisecond)
+					cache.SaveToken(context.Background(), Token{
+						Token:   "other-vault-token",
+						Expires: time.Now().Add(time.Hour),
+					})
+				}()
+			}
+
+			cfg := Config{
+				VaultAddress:          vaultSvr.URL,
+				AuthType:              AuthTypeAppRole,
+				AppRoleID:             "my-role-id",
+				SecretPath:            "my-secret-path",
+				TokenCache:            cache,
+				TokenCacheLock:        true,
+				TokenCacheLockTimeout: 1,
+			}
+			if _, err := GetSecrets(context.Background(), cfg); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if gotLogins != test.wantLogins {
+				t.Errorf("expected %d logins, got %d", test.wantLogins, gotLogins)
+			}
+			if cache.locked != test.wantLocked {
+				t.Errorf("expected the lock to be taken %d times, got %d", test.wantLocked, cache.locked)
+			}
+			if !test.givenHeld && cache.held {
+				t.Error("expected the lock to be released")
+			}
+		})
+	}
+}
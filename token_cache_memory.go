@@ -0,0 +1,72 @@
+package gcpvault
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// memoryTokenCache keeps tokens in memory, shared by every Client in the process
+// that logs in the same way. It is the TokenCache used when no other is configured.
+type memoryTokenCache struct {
+	key string
+}
+
+var memoryTokens = struct {
+	sync.Mutex
+	m map[string]Token
+}{m: map[string]Token{}}
+
+func (t memoryTokenCache) GetToken(ctx context.Context) (*Token, error) {
+	memoryTokens.Lock()
+	defer memoryTokens.Unlock()
+	token, ok := memoryTokens.m[t.key]
+	if !ok {
+		return nil, nil
+	}
+	// only tokens from our own logins are stored here
+	token.trusted = true
+	return &token, nil
+}
+
+func (t memoryTokenCache) SaveToken(ctx context.Context, token Token) error {
+	memoryTokens.Lock()
+	defer memoryTokens.Unlock()
+	memoryTokens.m[t.key] = token
+	return nil
+}
+
+// logins coalesces concurrent logins that would produce the same token.
+var logins singleflight.Group
+
+// detachedContext keeps the values of a context, which App Engine needs to reach
+// its APIs, but not its deadline or cancellation.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+// loginKey identifies the Vault address and credentials a login is made with. It
+// is empty for AuthMethods whose identity is unknown, such as custom ones or
+// TokenAuth, which are never coalesced or cached in memory.
+func loginKey(cfg Config) string {
+	var id []string
+	switch m := cfg.AuthMethod.(type) {
+	case gcpAuth:
+		id = []string{AuthTypeGCP, m.cfg.AuthPath, m.cfg.RoleType, m.cfg.Role, m.cfg.ImpersonateServiceAccount}
+	case KubernetesAuth:
+		id = []string{AuthTypeKubernetes, m.MountPath, m.Role, m.TokenPath}
+	case AppRoleAuth:
+		id = []string{AuthTypeAppRole, m.MountPath, m.RoleID}
+	default:
+		return ""
+	}
+	addrs := append([]string{cfg.VaultAddress}, cfg.VaultAddresses...)
+	return strings.Join(addrs, ",") + "|" + cfg.AuthNamespace + "|" + strings.Join(id, "|")
+}
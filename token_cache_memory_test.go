@@ -0,0 +1,239 @@
+package gcpvault
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/iam/v1"
+)
+
+func TestConcurrentLogins(t *testing.T) {
+	var gotIAMHits, gotLogins, gotLookups int32
+	iamSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&gotIAMHits, 1)
+		// give the other callers time to pile up behind this login
+		time.Sleep(100 * time.Millisecond)
+		json.NewEncoder(w).Encode(iam.SignJwtResponse{
+			SignedJwt: "gcp-signed-jwt-for-vault",
+		})
+	}))
+	defer iamSvr.Close()
+
+	vaultSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/gcp/login":
+			atomic.AddInt32(&gotLogins, 1)
+			json.NewEncoder(w).Encode(api.Secret{
+				Auth: &api.SecretAuth{ClientToken: "vault-test-token", LeaseDuration: 3600},
+			})
+		case "/v1/auth/token/lookup-self":
+			atomic.AddInt32(&gotLookups, 1)
+			json.NewEncoder(w).Encode(api.Secret{Data: map[string]interface{}{"ttl": 3600}})
+		default:
+			json.NewEncoder(w).Encode(api.Secret{Data: map[string]interface{}{"my-sec": "123"}})
+		}
+	}))
+	defer vaultSvr.Close()
+
+	findDefaultCredentials = func(ctx context.Context, scopes ...string) (*google.Credentials, error) {
+		return &google.Credentials{TokenSource: testTokenSource{}}, nil
+	}
+	defer func() {
+		findDefaultCredentials = google.FindDefaultCredentials
+	}()
+
+	cfg := Config{
+		VaultAddress:              vaultSvr.URL,
+		IAMAddress:                iamSvr.URL,
+		Role:                      "my-gcp-role",
+		ImpersonateServiceAccount: "svc@example.com",
+		SecretPath:                "my-secret-path",
+	}
+
+	const callers = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := GetSecrets(context.Background(), cfg)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	// a later call reuses the token without asking Vault about it
+	if _, err := GetSecrets(context.Background(), cfg); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if gotIAMHits != 1 {
+		t.Errorf("expected 1 signJwt request, got %d", gotIAMHits)
+	}
+	if gotLogins != 1 {
+		t.Errorf("expected 1 login, got %d", gotLogins)
+	}
+	if gotLookups != 0 {
+		t.Errorf("expected no token lookups, got %d", gotLookups)
+	}
+}
+
+func TestMemoryTokenRevoked(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		gotLogins int
+	)
+	vaultSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.URL.Path == "/v1/auth/approle/login":
+			gotLogins++
+			json.NewEncoder(w).Encode(api.Secret{
+				Auth: &api.SecretAuth{ClientToken: fmt.Sprintf("vault-test-token-%d", gotLogins), LeaseDuration: 3600},
+			})
+		case r.Header.Get("X-Vault-Token") == "vault-test-token-1" && gotLogins > 0:
+			// the first token has been revoked
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string][]string{"errors": {"permission denied"}})
+		default:
+			json.NewEncoder(w).Encode(api.Secret{Data: map[string]interface{}{"my-sec": "123"}})
+		}
+	}))
+	defer vaultSvr.Close()
+
+	cfg := Config{
+		VaultAddress: vaultSvr.URL,
+		AuthType:     AuthTypeAppRole,
+		AppRoleID:    "my-role-id",
+		SecretPath:   "my-secret-path",
+	}
+	// log in once so the first token is kept in memory
+	if err := checkDefaults(&cfg); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, _, err := login(context.Background(), cfg, true); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		got, err := GetSecrets(context.Background(), cfg)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if got["my-sec"] != "123" {
+			t.Errorf("expected secrets, got %v", got)
+		}
+	}
+	if gotLogins != 2 {
+		t.Errorf("expected 2 logins, got %d", gotLogins)
+	}
+}
+
+func TestMemoryTokenPolicyDenied(t *testing.T) {
+	var (
+		mu                    sync.Mutex
+		gotLogins, gotLookups int
+	)
+	vaultSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/v1/auth/approle/login":
+			gotLogins++
+			json.NewEncoder(w).Encode(api.Secret{
+				Auth: &api.SecretAuth{ClientToken: "vault-test-token", LeaseDuration: 3600},
+			})
+		case "/v1/auth/token/lookup-self":
+			gotLookups++
+			json.NewEncoder(w).Encode(api.Secret{Data: map[string]interface{}{"ttl": 3600}})
+		default:
+			// the token is fine, but its policies do not allow reading the path
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string][]string{"errors": {"permission denied"}})
+		}
+	}))
+	defer vaultSvr.Close()
+
+	cfg := Config{
+		VaultAddress: vaultSvr.URL,
+		AuthType:     AuthTypeAppRole,
+		AppRoleID:    "my-role-id",
+		SecretPath:   "my-forbidden-path",
+	}
+	for i := 0; i < 2; i++ {
+		_, err := GetSecrets(context.Background(), cfg)
+		if !errors.Is(err, ErrPermissionDenied) {
+			t.Fatalf("expected ErrPermissionDenied, got %v", err)
+		}
+	}
+
+	if gotLogins != 1 {
+		t.Errorf("expected 1 login, got %d", gotLogins)
+	}
+	// only the call reusing the token from memory needs to look it up
+	if gotLookups != 1 {
+		t.Errorf("expected 1 token lookup, got %d", gotLookups)
+	}
+}
+
+func TestConcurrentLoginsCancelled(t *testing.T) {
+	var gotLogins int32
+	vaultSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/approle/login":
+			atomic.AddInt32(&gotLogins, 1)
+			time.Sleep(200 * time.Millisecond)
+			json.NewEncoder(w).Encode(api.Secret{
+				Auth: &api.SecretAuth{ClientToken: "vault-test-token", LeaseDuration: 3600},
+			})
+		default:
+			json.NewEncoder(w).Encode(api.Secret{Data: map[string]interface{}{"my-sec": "123"}})
+		}
+	}))
+	defer vaultSvr.Close()
+
+	cfg := Config{
+		VaultAddress: vaultSvr.URL,
+		AuthType:     AuthTypeAppRole,
+		AppRoleID:    "my-role-id",
+		SecretPath:   "my-secret-path",
+	}
+
+	// the caller that starts the login gives up before it completes
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	errs := make(chan error, 1)
+	go func() {
+		_, err := GetSecrets(ctx, cfg)
+		errs <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	if _, err := GetSecrets(context.Background(), cfg); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := <-errs; err == nil {
+		t.Error("expected the cancelled call to fail")
+	}
+	if gotLogins != 1 {
+		t.Errorf("expected 1 login, got %d", gotLogins)
+	}
+}
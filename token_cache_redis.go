@@ -2,6 +2,8 @@ package gcpvault
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"github.com/gomodule/redigo/redis"
 	"github.com/pkg/errors"
@@ -17,7 +19,7 @@ func (t TokenCacheRedis) GetToken(ctx context.Context) (*Token, error) {
 	if t.cfg.TokenCache != nil {
 
 		redisAddr := t.cfg.TokenCacheStorageRedis
-		tokenKey := t.cfg.TokenCacheKeyName
+		tokenKey := tokenCacheKey(t.cfg)
 		tokenDB := t.cfg.TokenCacheStorageRedisDB
 		opts := []redis.DialOption{redis.DialConnectTimeout(time.Second * time.Duration(t.cfg.TokenCacheCtxTimeout)), redis.DialDatabase(tokenDB)}
 
@@ -50,7 +52,7 @@ func (t TokenCacheRedis) SaveToken(ctx context.Context, token Token) error {
 	if t.cfg.TokenCache != nil {
 
 		redisAddr := t.cfg.TokenCacheStorageRedis
-		tokenKey := t.cfg.TokenCacheKeyName
+		tokenKey := tokenCacheKey(t.cfg)
 		tokenDB := t.cfg.TokenCacheStorageRedisDB
 		opts := []redis.DialOption{redis.DialConnectTimeout(time.Second * time.Duration(t.cfg.TokenCacheCtxTimeout)), redis.DialDatabase(tokenDB)}
 
@@ -73,3 +75,47 @@ func (t TokenCacheRedis) SaveToken(ctx context.Context, token Token) error {
 
 	return nil
 }
+
+// unlockScript deletes the lock only if it is still ours, as it may have expired
+// and been taken by another instance.
+var unlockScript = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// LockToken sets a lock key next to the token with SET NX PX, so it expires after
+// ttl if this instance stops before releasing it.
+func (t TokenCacheRedis) LockToken(ctx context.Context, ttl time.Duration) (func(), bool, error) {
+	redisAddr := t.cfg.TokenCacheStorageRedis
+	lockKey := tokenCacheKey(t.cfg) + ".lock"
+	tokenDB := t.cfg.TokenCacheStorageRedisDB
+	opts := []redis.DialOption{redis.DialConnectTimeout(time.Second * time.Duration(t.cfg.TokenCacheCtxTimeout)), redis.DialDatabase(tokenDB)}
+
+	conn, err := redis.Dial("tcp", redisAddr, opts...)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "error connecting")
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		conn.Close()
+		return nil, false, errors.Wrap(err, "unable to generate lock id")
+	}
+	value := hex.EncodeToString(id)
+
+	_, err = redis.String(conn.Do("SET", lockKey, value, "NX", "PX", ttl.Milliseconds()))
+	if err == redis.ErrNil {
+		// another instance holds the lock
+		conn.Close()
+		return nil, false, nil
+	}
+	if err != nil {
+		conn.Close()
+		return nil, false, errors.Wrap(err, "error locking")
+	}
+	return func() {
+		unlockScript.Do(conn, lockKey, value)
+		conn.Close()
+	}, true, nil
+}
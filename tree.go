@@ -0,0 +1,200 @@
+package gcpvault
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// ListSecrets lists the keys under the configured SecretPath. If KVMount is set, the
+// keys are listed from the KV v2 metadata of SecretPath within that mount. Keys
+// ending in '/' are folders containing further keys.
+// This is comparable to the `vault kv list` command.
+func ListSecrets(ctx context.Context, cfg Config) ([]string, error) {
+	c, err := newOneShotClient(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if c.cfg.KVMount != "" {
+		return c.ListKVSecrets(ctx, c.cfg.KVMount, c.cfg.SecretPath)
+	}
+	return c.ListSecrets(ctx, c.cfg.SecretPath)
+}
+
+// GetSecretTree reads every secret under the configured SecretPath, walking into
+// folders recursively, and returns them keyed by their path. If KVMount is set, the
+// tree is read from that KV v2 mount and secrets are keyed by their path within it.
+// Secrets are read concurrently, up to MaxConcurrency at a time, and all share a
+// single login.
+func GetSecretTree(ctx context.Context, cfg Config) (map[string]map[string]interface{}, error) {
+	c, err := newOneShotClient(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if c.cfg.KVMount != "" {
+		return c.GetKVSecretTree(ctx, c.cfg.KVMount, c.cfg.SecretPath)
+	}
+	return c.GetSecretTree(ctx, c.cfg.SecretPath)
+}
+
+// ListSecrets lists the keys under the given path. Keys ending in '/' are folders
+// containing further keys.
+func (c *Client) ListSecrets(ctx context.Context, path string) ([]string, error) {
+	var secret *api.Secret
+	err := c.withVault(ctx, func(vClient *api.Client) (err error) {
+		secret, err = vClient.Logical().ListWithContext(ctx, path)
+		return wrapVaultError(err, "unable to list secrets")
+	})
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, nil
+	}
+	raw, ok := secret.Data["keys"].([]interface{})
+	if !ok {
+		return nil, errors.New("unexpected list response")
+	}
+	keys := make([]string, 0, len(raw))
+	for _, k := range raw {
+		key, ok := k.(string)
+		if !ok {
+			return nil, errors.New("unexpected list response")
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// ListKVSecrets lists the keys under the given key in a KV v2 mount.
+func (c *Client) ListKVSecrets(ctx context.Context, mount, key string) ([]string, error) {
+	return c.ListSecrets(ctx, kvPath(mount, "metadata", key))
+}
+
+// GetSecretTree reads every secret under the given path, walking into folders
+// recursively, and returns them keyed by their full path.
+func (c *Client) GetSecretTree(ctx context.Context, path string) (map[string]map[string]interface{}, error) {
+	return c.walkTree(ctx, path, c.ListSecrets, c.GetSecrets)
+}
+
+// GetKVSecretTree reads the latest version of every secret under the given key in a
+// KV v2 mount, walking into folders recursively, and returns them keyed by their
+// path within the mount. Secrets whose latest version is deleted are left out.
+func (c *Client) GetKVSecretTree(ctx context.Context, mount, key string) (map[string]map[string]interface{}, error) {
+	list := func(ctx context.Context, key string) ([]string, error) {
+		return c.ListKVSecrets(ctx, mount, key)
+	}
+	read := func(ctx context.Context, key string) (map[string]interface{}, error) {
+		secret, err := c.GetKVSecret(ctx, mount, key, 0)
+		if err != nil {
+			return nil, err
+		}
+		return secret.Data, nil
+	}
+	return c.walkTree(ctx, key, list, read)
+}
+
+func kvPath(mount, op, key string) string {
+	return strings.Trim(mount, "/") + "/" + op + "/" + strings.TrimPrefix(key, "/")
+}
+
+// walkTree lists root and reads every secret beneath it, descending into folders.
+// At most MaxConcurrency Vault requests are in flight at once and the walk stops at
+// the first error.
+func (c *Client) walkTree(ctx context.Context, root string,
+	list func(context.Context, string) ([]string, error),
+	read func(context.Context, string) (map[string]interface{}, error),
+) (map[string]map[string]interface{}, error) {
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		sem      = make(chan struct{}, c.cfg.MaxConcurrency)
+		tree     = map[string]map[string]interface{}{}
+	)
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+	// acquire reports false once the walk is cancelled, by an error or by the
+	// caller, which is recorded so a partial tree is never returned
+	acquire := func() bool {
+		select {
+		case sem <- struct{}{}:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	var walk func(folder string)
+	walk = func(folder string) {
+		defer wg.Done()
+		if !acquire() {
+			fail(ctx.Err())
+			return
+		}
+		keys, err := list(ctx, folder)
+		<-sem
+		if err != nil {
+			fail(errors.Wrapf(err, "unable to list %s", folder))
+			return
+		}
+
+		for _, key := range keys {
+			p := folder + key
+			wg.Add(1)
+			if strings.HasSuffix(key, "/") {
+				go walk(p)
+				continue
+			}
+			go func() {
+				defer wg.Done()
+				if !acquire() {
+					fail(ctx.Err())
+					return
+				}
+				data, err := read(ctx, p)
+				<-sem
+				if err != nil {
+					fail(errors.Wrapf(err, "unable to read %s", p))
+					return
+				}
+				if data == nil {
+					return
+				}
+				mu.Lock()
+				tree[p] = data
+				mu.Unlock()
+			}()
+		}
+	}
+
+	root = strings.TrimSuffix(root, "/")
+	if root != "" {
+		root += "/"
+	}
+	wg.Add(1)
+	walk(root)
+	wg.Wait()
+
+	if firstErr == nil {
+		// the caller may have given up after every request was made
+		firstErr = parent.Err()
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return tree, nil
+}
@@ -0,0 +1,93 @@
+package gcpvault
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/NYTimes/gcp-vault/gcpvaulttest"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestGetSecretTree(t *testing.T) {
+	vaultSvr := gcpvaulttest.NewKVServer("secret", map[string]map[string]interface{}{
+		"my-app/tenants/a":          {"my-sec": "a"},
+		"my-app/tenants/b":          {"my-sec": "b"},
+		"my-app/tenants/regions/us": {"my-sec": "us"},
+		"my-other-app/creds":        {"my-sec": "nope"},
+	})
+	defer vaultSvr.Close()
+
+	ctx := context.Background()
+	cfg := Config{
+		VaultAddress:   vaultSvr.URL,
+		LocalToken:     "my-local-token",
+		KVMount:        "secret",
+		SecretPath:     "my-app/tenants",
+		MaxConcurrency: 2,
+	}
+
+	keys, err := ListSecrets(ctx, cfg)
+	if err != nil {
+		t.Fatalf("unable to list secrets: %s", err)
+	}
+	if want := []string{"a", "b", "regions/"}; !cmp.Equal(want, keys) {
+		t.Errorf("keys differ: (-want +got)\n%s", cmp.Diff(want, keys))
+	}
+
+	tree, err := GetSecretTree(ctx, cfg)
+	if err != nil {
+		t.Fatalf("unable to get secret tree: %s", err)
+	}
+	want := map[string]map[string]interface{}{
+		"my-app/tenants/a":          {"my-sec": "a"},
+		"my-app/tenants/b":          {"my-sec": "b"},
+		"my-app/tenants/regions/us": {"my-sec": "us"},
+	}
+	if !cmp.Equal(want, tree) {
+		t.Errorf("tree differs: (-want +got)\n%s", cmp.Diff(want, tree))
+	}
+}
+
+func TestWalkTreeCancelled(t *testing.T) {
+	keys := []string{"a", "b", "c", "d"}
+	list := func(ctx context.Context, path string) ([]string, error) {
+		return keys, nil
+	}
+
+	tests := []struct {
+		name string
+
+		givenCancelled bool
+	}{
+		{
+			name: "cancelled during the walk",
+		},
+		{
+			name: "cancelled before the walk",
+
+			givenCancelled: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if test.givenCancelled {
+				cancel()
+			}
+			read := func(ctx context.Context, path string) (map[string]interface{}, error) {
+				// give up once the first secret is read, leaving the others waiting
+				cancel()
+				return map[string]interface{}{"my-sec": path}, nil
+			}
+
+			c := &Client{cfg: Config{MaxConcurrency: 1}}
+			tree, err := c.walkTree(ctx, "my-app", list, read)
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("expected context.Canceled, got %v with tree %v", err, tree)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package gcpvault
+
+import (
+	"context"
+	"math/rand"
+	"reflect"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// WatchConfig controls how Watch polls Vault for changes to a secret.
+type WatchConfig struct {
+	// Interval is how often the secret is read from Vault. Default is 1 minute.
+	Interval time.Duration
+
+	// Jitter is the maximum random duration added to each Interval to avoid all
+	// instances polling Vault at once. Default is 1/10 of the Interval.
+	Jitter time.Duration
+
+	// Versioned should be set when watching a KV v2 path. Changes are then detected
+	// by the version in the secret's metadata rather than by comparing contents.
+	Versioned bool
+}
+
+// WatchIntervalDefault is the polling interval used by Watch when one is not given.
+const WatchIntervalDefault = time.Minute
+
+// SecretChange is delivered by Watch with the new contents of a secret, or with the
+// error encountered while reading it.
+type SecretChange struct {
+	Secrets map[string]interface{}
+
+	// Version is the KV v2 version of Secrets. It is only set when watching a
+	// versioned path.
+	Version int
+
+	Err error
+}
+
+// Watch polls the secrets under the configured SecretPath until ctx is cancelled,
+// delivering the current contents first and then again every time they change.
+// See Client.Watch for details.
+func Watch(ctx context.Context, cfg Config, wcfg WatchConfig) (<-chan SecretChange, error) {
+	c, err := NewClient(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	changes := c.Watch(ctx, c.cfg.SecretPath, wcfg)
+	go func() {
+		<-ctx.Done()
+		c.Close()
+	}()
+	return changes, nil
+}
+
+// Watch polls the secrets at the given path until ctx is cancelled, delivering the
+// current contents first and then again every time they change. Failed reads are
+// delivered with Err set and polling continues. The returned channel is closed once
+// ctx is cancelled.
+func (c *Client) Watch(ctx context.Context, path string, wcfg WatchConfig) <-chan SecretChange {
+	if wcfg.Interval <= 0 {
+		wcfg.Interval = WatchIntervalDefault
+	}
+	if wcfg.Jitter == 0 {
+		wcfg.Jitter = wcfg.Interval / 10
+	}
+
+	changes := make(chan SecretChange, 1)
+	go func() {
+		defer close(changes)
+
+		var (
+			last   SecretChange
+			loaded bool
+		)
+		for {
+			change := c.poll(ctx, path, wcfg.Versioned)
+			if change.Err != nil || !loaded || changed(last, change, wcfg.Versioned) {
+				select {
+				case changes <- change:
+				case <-ctx.Done():
+					return
+				}
+				if change.Err == nil {
+					last, loaded = change, true
+				}
+			}
+
+			wait := wcfg.Interval
+			if wcfg.Jitter > 0 {
+				wait += time.Duration(rand.Int63n(int64(wcfg.Jitter)))
+			}
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return changes
+}
+
+func (c *Client) poll(ctx context.Context, path string, versioned bool) SecretChange {
+	var change SecretChange
+	if versioned {
+		change.Err = c.withVault(ctx, func(vClient *api.Client) (err error) {
+			change.Secrets, change.Version, err = readVersionedSecretsWithVersion(ctx, vClient, path)
+			return err
+		})
+		return change
+	}
+	change.Secrets, change.Err = c.GetSecrets(ctx, path)
+	return change
+}
+
+func changed(last, next SecretChange, versioned bool) bool {
+	if versioned && last.Version != 0 && next.Version != 0 {
+		return last.Version != next.Version
+	}
+	return !reflect.DeepEqual(last.Secrets, next.Secrets)
+}
@@ -0,0 +1,112 @@
+package gcpvault
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/NYTimes/gcp-vault/gcpvaulttest"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWatch(t *testing.T) {
+	vaultSvr := gcpvaulttest.NewVaultServer(map[string]interface{}{
+		"my-sec": "123",
+	})
+	defer vaultSvr.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := NewClient(ctx, Config{
+		VaultAddress: vaultSvr.URL,
+		LocalToken:   "my-local-token",
+	})
+	if err != nil {
+		t.Fatalf("unable to create client: %s", err)
+	}
+
+	changes := c.Watch(ctx, "my-secret-path", WatchConfig{Interval: 10 * time.Millisecond})
+
+	wants := []map[string]interface{}{
+		{"my-sec": "123"},
+		{"my-sec": "456"},
+	}
+	for i, want := range wants {
+		select {
+		case got := <-changes:
+			if got.Err != nil {
+				t.Fatalf("expected no error, got %s", got.Err)
+			}
+			if !cmp.Equal(want, got.Secrets) {
+				t.Errorf("secrets differ: (-want +got)\n%s", cmp.Diff(want, got.Secrets))
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for change %d", i)
+		}
+
+		if i == 0 {
+			err = c.PutSecrets(ctx, "my-secret-path", map[string]interface{}{"my-sec": "456"})
+			if err != nil {
+				t.Fatalf("unable to put secrets: %s", err)
+			}
+		}
+	}
+
+	cancel()
+	for range changes {
+	}
+}
+
+func TestWatchVersioned(t *testing.T) {
+	vaultSvr := gcpvaulttest.NewKVServer("secret", map[string]map[string]interface{}{
+		"my-app": {"my-sec": "123"},
+	})
+	defer vaultSvr.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := NewClient(ctx, Config{
+		VaultAddress: vaultSvr.URL,
+		LocalToken:   "my-local-token",
+	})
+	if err != nil {
+		t.Fatalf("unable to create client: %s", err)
+	}
+
+	changes := c.Watch(ctx, "secret/data/my-app", WatchConfig{
+		Interval:  10 * time.Millisecond,
+		Versioned: true,
+	})
+
+	// writing the same contents again is still a new version
+	wants := []SecretChange{
+		{Secrets: map[string]interface{}{"my-sec": "123"}, Version: 1},
+		{Secrets: map[string]interface{}{"my-sec": "123"}, Version: 2},
+	}
+	for i, want := range wants {
+		select {
+		case got := <-changes:
+			if got.Err != nil {
+				t.Fatalf("expected no error, got %s", got.Err)
+			}
+			if !cmp.Equal(want, got) {
+				t.Errorf("change differs: (-want +got)\n%s", cmp.Diff(want, got))
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for change %d", i)
+		}
+
+		if i == 0 {
+			err = c.PutVersionedSecrets(ctx, "secret/data/my-app", map[string]interface{}{"my-sec": "123"})
+			if err != nil {
+				t.Fatalf("unable to put secrets: %s", err)
+			}
+		}
+	}
+
+	cancel()
+	for range changes {
+	}
+}